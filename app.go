@@ -0,0 +1,194 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/gorilla/csrf"
+)
+
+// App holds everything the handlers need, so that they can be given a real database or a fake one.
+type App struct {
+	Links        LinkStore
+	Users        UserStore
+	Sessions     *SessionManager
+	LoginLimiter *RateLimiter
+
+	CSRFKey []byte
+	// Secure marks cookies as HTTPS-only.
+	Secure bool
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header gives the client's address.
+	TrustedProxies []*net.IPNet
+
+	PageSize int
+	// PublicProfiles serves each user's public links at /u/{name}/links/public.
+	PublicProfiles bool
+
+	// Metrics, if set, records requests and serves /metrics.
+	Metrics *Metrics
+	// CheckReady, if set, reports why the app can't serve requests for /readyz.
+	CheckReady func() error
+	// Logger is the base for each request's logger; the default logger is used if it isn't set.
+	Logger *slog.Logger
+}
+
+// links returns the link store for handling the request.
+func (app *App) links(r *http.Request) LinkStore {
+	return forRequest(app.Links, r)
+}
+
+// users returns the user store for handling the request.
+func (app *App) users(r *http.Request) UserStore {
+	return forRequest(app.Users, r)
+}
+
+func (app *App) Router() http.Handler { //nolint:funlen
+	router := chi.NewRouter()
+	router.Use(trustProxies(app.TrustedProxies))
+	router.Use(middleware.RequestID)
+
+	if app.Metrics != nil {
+		router.Use(app.Metrics.instrument)
+	}
+
+	router.Use(func(next http.Handler) http.Handler {
+		if app.Logger == nil {
+			return logRequests(slog.Default(), next)
+		}
+
+		return logRequests(app.Logger, next)
+	})
+	router.Use(middleware.GetHead)
+	router.Use(middleware.Recoverer)
+	router.Use(middleware.Compress(5))
+	// a .json suffix selects the format, and is stripped before routing so that /links.json reaches /links
+	router.Use(middleware.URLFormat)
+
+	csrfMiddleware := csrf.Protect(app.CSRFKey,
+		csrf.Secure(app.Secure),
+		csrf.Path("/"),
+	)
+
+	router.Use(skipCSRFForBearerTokens)
+	router.Use(csrfMiddleware)
+
+	router.Use(func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, 10*time.Second, "Request timed out")
+	})
+
+	router.Use(app.loadCurrentUser)
+
+	router.Get("/healthz", healthzHandler)
+	router.Get("/readyz", app.readyzHandler)
+
+	if app.Metrics != nil {
+		router.Handle("/metrics", app.Metrics)
+	}
+
+	router.Get("/auth/login/", loginFormHandler)
+	router.Post("/auth/login/", app.loginHandler)
+	router.Get("/auth/login/2fa", app.loginTOTPFormHandler)
+	router.Post("/auth/login/2fa", app.loginTOTPHandler)
+	router.Post("/auth/logout/", app.logoutHandler)
+
+	router.Route("/auth/2fa", func(router chi.Router) {
+		router.Use(rejectUnauthenticated)
+
+		router.Get("/", twoFactorHandler)
+		router.Post("/setup", app.twoFactorSetupHandler)
+		router.Post("/enable", app.twoFactorEnableHandler)
+		router.Post("/disable", app.twoFactorDisableHandler)
+		router.Post("/recovery-codes", app.twoFactorRecoveryCodesHandler)
+	})
+
+	router.With(rejectUnauthenticated).Get("/auth/password/", passwordFormHandler)
+	router.With(rejectUnauthenticated).Post("/auth/password/", app.passwordHandler)
+
+	router.Route("/auth/sessions", func(router chi.Router) {
+		router.Use(rejectUnauthenticated)
+
+		router.Get("/", app.sessionsHandler)
+		router.Post("/revoke-all", app.revokeAllSessionsHandler)
+		router.Post("/{id}/revoke", app.revokeSessionHandler)
+	})
+
+	router.Route("/links", func(router chi.Router) {
+		router.Use(app.authenticateAPIToken)
+		router.Use(ownedByCurrentUser)
+
+		router.Use(middleware.Maybe(middleware.WithValue("onlyPublic", true), func(r *http.Request) bool {
+			return !isAuthenticated(r)
+		},
+		))
+
+		router.Get("/", app.indexHandler)
+		router.With(middleware.WithValue("onlyPublic", true)).Route("/public", func(router chi.Router) {
+			router.Get("/", app.indexHandler)
+			router.Get("/page/{page}", app.indexHandler)
+		})
+		router.With(middleware.WithValue("onlyRead", true)).Route("/read", func(router chi.Router) {
+			router.Get("/", app.indexHandler)
+			router.Get("/page/{page}", app.indexHandler)
+		})
+		router.With(middleware.WithValue("onlyUnread", true)).Route("/unread", func(router chi.Router) {
+			router.Get("/", app.indexHandler)
+			router.Get("/page/{page}", app.indexHandler)
+			router.With(rejectUnauthenticated).Get("/random", app.randomUnreadHandler)
+		})
+
+		router.Get("/page/{page}", app.indexHandler)
+
+		router.Route("/trash", func(router chi.Router) {
+			router.Use(rejectUnauthenticated)
+
+			router.Get("/", app.trashHandler)
+			router.Get("/page/{page}", app.trashHandler)
+			router.Post("/restore", app.restoreHandler)
+			router.Post("/purge", app.purgeHandler)
+			router.Post("/empty", app.emptyTrashHandler)
+			router.Post("/{id}/restore", app.restoreHandler)
+			router.Post("/{id}/purge", app.purgeHandler)
+		})
+
+		router.With(rejectUnauthenticated).Get("/new", app.formHandler)
+		router.With(rejectUnauthenticated).Post("/", app.saveHandler)
+		router.With(rejectUnauthenticated).Post("/bulk", app.bulkHandler)
+
+		router.Route("/{id}", func(router chi.Router) {
+			router.Use(rejectUnauthenticated)
+
+			router.Get("/", app.showHandler)
+			router.Put("/", app.saveHandler)
+			router.Post("/", app.saveHandler)
+			router.Delete("/", app.deleteHandler)
+			router.Post("/delete", app.deleteHandler)
+			router.Post("/read", app.linkActionHandler(markLinkRead))
+			router.Post("/unread", app.linkActionHandler(markLinkUnread))
+			router.Post("/public", app.linkActionHandler(makeLinkPublic))
+			router.Post("/private", app.linkActionHandler(makeLinkPrivate))
+			router.Get("/edit", app.formHandler)
+			router.With(middleware.WithValue("showHistory", true)).Get("/history", app.showHandler)
+			router.Post("/revisions/{revision}/revert", app.revertHandler)
+		})
+	})
+
+	if app.PublicProfiles {
+		router.Route("/u/{name}", func(router chi.Router) {
+			router.Use(app.ownedByProfileUser)
+
+			router.With(middleware.WithValue("onlyPublic", true)).Route("/links/public", func(router chi.Router) {
+				router.Get("/", app.indexHandler)
+				router.Get("/page/{page}", app.indexHandler)
+			})
+		})
+	}
+
+	fs := http.FileServer(http.Dir("static"))
+	router.Handle("/static/*", http.StripPrefix("/static/", fs))
+
+	return router
+}
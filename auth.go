@@ -1,46 +1,254 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/alexedwards/argon2id"
-	"github.com/benjamineskola/bookmarks/database"
 	"gorm.io/gorm"
 )
 
+const apiTokenBytes = 32
+
+var (
+	errInvalidPassword = errors.New("invalid password")
+	errEmptyPassword   = errors.New("password must not be empty")
+)
+
 type User struct {
 	gorm.Model
 
+	Name     string
 	Email    string
 	Password string
+
+	TOTPSecret   string
+	TOTPEnabled  bool
+	TOTPLastStep int64
+
+	// APITokenHash authenticates the command-line client; only the hash of the token is kept.
+	APITokenHash string
 }
 
-func NewUser(email string, password string) (*User, error) {
-	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
-	if err != nil {
-		return nil, fmt.Errorf("could not create password: %w", err)
+type FailedLogin struct {
+	ID        uint
+	CreatedAt time.Time
+	Email     string
+	IPAddress string
+	Reason    string
+}
+
+func NewUser(name string, email string, password string) (*User, error) {
+	email = normaliseEmail(email)
+
+	if name == "" {
+		name = defaultUserName(email)
 	}
 
-	user := User{Email: email, Password: hash} //nolint:exhaustruct
+	user := User{Name: name, Email: email} //nolint:exhaustruct
+
+	if err := user.SetPassword(password); err != nil {
+		return nil, err
+	}
 
 	return &user, nil
 }
 
-func GetUserByEmail(email string) *User {
+// normaliseEmail returns the form of an email address which is stored and looked up, so that it matches
+// however it is capitalised.
+func normaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// accountLimitKey is the login rate limiter's key for the account with the email address.
+func accountLimitKey(email string) string {
+	return "account:" + normaliseEmail(email)
+}
+
+// defaultUserName is the name of a user who wasn't given one: the local part of their email address.
+func defaultUserName(email string) string {
+	name, _, _ := strings.Cut(email, "@")
+
+	return name
+}
+
+// freeUserName returns the name, or if another user already has it, the name with the lowest number appended
+// which is free.
+func freeUserName(users UserStore, name string) string {
+	candidate := name
+
+	for i := 2; users.GetUserByName(candidate).ID != 0; i++ {
+		candidate = fmt.Sprintf("%s-%d", name, i)
+	}
+
+	return candidate
+}
+
+func (s *GormStore) GetUserByID(id uint) *User {
 	var user User
 
-	database.DB.Where("email = ?", email).First(&user)
+	s.db.First(&user, id)
 
 	return &user
 }
 
-func GetValidatedUser(email string, password string) (*User, error) {
-	user := GetUserByEmail(email)
+func (s *GormStore) GetUserByEmail(email string) *User {
+	var user User
+
+	// addresses stored before they were normalised may not be lowercase
+	s.db.Where("lower(email) = ?", normaliseEmail(email)).First(&user)
+
+	return &user
+}
+
+func (s *GormStore) GetUserByName(name string) *User {
+	var user User
+
+	s.db.Where("name = ?", name).First(&user)
+
+	return &user
+}
+
+func (s *GormStore) GetUserByAPITokenHash(tokenHash string) *User {
+	var user User
+
+	if tokenHash != "" {
+		s.db.Where("api_token_hash = ?", tokenHash).First(&user)
+	}
+
+	return &user
+}
+
+func (s *GormStore) GetUsers() []User {
+	var users []User
+
+	s.db.Order("id").Find(&users)
+
+	return users
+}
+
+func (s *GormStore) CreateUser(user *User) error {
+	if err := s.db.Create(user).Error; err != nil {
+		return fmt.Errorf("could not create user: %w", err)
+	}
+
+	return nil
+}
+
+func (s *GormStore) UpdateUser(user *User, columns ...string) error {
+	if err := s.db.Model(user).Select(columns).Updates(user).Error; err != nil {
+		return fmt.Errorf("could not save user: %w", err)
+	}
+
+	return nil
+}
+
+func (s *GormStore) DeleteUser(user *User) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		linkIDs := tx.Unscoped().Model(&Link{}).Where("user_id = ?", user.ID).Select("id") //nolint:exhaustruct
+
+		if err := tx.Where("link_id in (?)", linkIDs).Delete(&LinkRevision{}).Error; err != nil { //nolint:exhaustruct
+			return err //nolint:wrapcheck
+		}
 
-	_, err := argon2id.ComparePasswordAndHash(password, user.Password)
+		for _, model := range []interface{}{&Link{}, &Session{}, &RecoveryCode{}} { //nolint:exhaustruct
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err //nolint:wrapcheck
+			}
+		}
+
+		return tx.Unscoped().Delete(user).Error //nolint:wrapcheck
+	})
 	if err != nil {
-		return nil, fmt.Errorf("could not verify password: %w", err)
+		return fmt.Errorf("could not delete user: %w", err)
+	}
+
+	return nil
+}
+
+func (s *GormStore) LogFailedLogin(email string, ipAddress string, reason string) error {
+	attempt := FailedLogin{Email: email, IPAddress: ipAddress, Reason: reason} //nolint:exhaustruct
+
+	if err := s.db.Create(&attempt).Error; err != nil {
+		return fmt.Errorf("could not log failed login: %w", err)
+	}
+
+	return nil
+}
+
+// GetValidatedUser returns the user if the password is correct, rehashing it if the hash used outdated parameters.
+func GetValidatedUser(users UserStore, email string, password string) (*User, error) {
+	user := users.GetUserByEmail(email)
+
+	if err := user.CheckPassword(password); err != nil {
+		return nil, err
+	}
+
+	if user.NeedsRehash() {
+		err := user.SetPassword(password)
+		if err == nil {
+			err = users.UpdateUser(user, "password")
+		}
+
+		if err != nil {
+			slog.Warn("could not rehash password", "user", user.Name, "error", err)
+		}
 	}
 
 	return user, nil
 }
+
+func (u *User) CheckPassword(password string) error {
+	match, err := argon2id.ComparePasswordAndHash(password, u.Password)
+	if err != nil {
+		return fmt.Errorf("could not verify password: %w", err)
+	}
+
+	if !match {
+		return errInvalidPassword
+	}
+
+	return nil
+}
+
+// NeedsRehash reports whether the password hash was made with parameters other than the current defaults.
+func (u *User) NeedsRehash() bool {
+	params, _, _, err := argon2id.DecodeHash(u.Password)
+
+	return err == nil && *params != *argon2id.DefaultParams
+}
+
+// SetPassword hashes the new password; the caller is responsible for saving it.
+func (u *User) SetPassword(password string) error {
+	if password == "" {
+		return errEmptyPassword
+	}
+
+	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+	if err != nil {
+		return fmt.Errorf("could not create password: %w", err)
+	}
+
+	u.Password = hash
+
+	return nil
+}
+
+// ResetAPIToken replaces the user's API token with a new one, which is returned; the caller is responsible for
+// saving it.
+func (u *User) ResetAPIToken() (string, error) {
+	tokenBytes := make([]byte, apiTokenBytes)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", fmt.Errorf("could not generate API token: %w", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+	u.APITokenHash = hashSessionToken(token)
+
+	return token, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetValidatedUserRehashes(t *testing.T) {
+	t.Parallel()
+
+	user, err := NewUser("rehash", "rehash@example.com", "password")
+	assert.Nil(t, err)
+
+	oldParams := *argon2id.DefaultParams
+	oldParams.Iterations++
+	user.Password, err = argon2id.CreateHash("password", &oldParams)
+	assert.Nil(t, err)
+	assert.Nil(t, testStore.CreateUser(user))
+	assert.True(t, user.NeedsRehash())
+
+	_, err = GetValidatedUser(testStore, "rehash@example.com", "wrong")
+	assert.ErrorIs(t, err, errInvalidPassword)
+
+	_, err = GetValidatedUser(testStore, "rehash@example.com", "password")
+	assert.Nil(t, err)
+
+	params, _, _, err := argon2id.DecodeHash(testStore.GetUserByID(user.ID).Password)
+	assert.Nil(t, err)
+	assert.Equal(t, *argon2id.DefaultParams, *params)
+}
+
+func TestFreeUserName(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryStore()
+	assert.Equal(t, "ben", freeUserName(store, defaultUserName("ben@a.com")))
+
+	for _, email := range []string{"ben@a.com", "ben@b.com"} {
+		user, err := NewUser(freeUserName(store, defaultUserName(email)), email, "password")
+		assert.Nil(t, err)
+		assert.Nil(t, store.CreateUser(user))
+	}
+
+	assert.Equal(t, "ben-2", store.GetUserByEmail("ben@b.com").Name)
+	assert.Equal(t, "ben-3", freeUserName(store, "ben"))
+}
+
+func TestEmailIgnoresCase(t *testing.T) {
+	t.Parallel()
+
+	user, err := NewUser("", " Mixed.Case@Example.com", "password")
+	assert.Nil(t, err)
+	assert.Equal(t, "mixed.case@example.com", user.Email)
+	assert.Equal(t, "mixed.case", user.Name)
+
+	// as if it had been stored before addresses were normalised
+	user.Email = "Mixed.Case@Example.com"
+	assert.Nil(t, testStore.CreateUser(user))
+
+	_, err = GetValidatedUser(testStore, "MIXED.case@example.COM", "password")
+	assert.Nil(t, err)
+	assert.Equal(t, user.ID, testStore.GetUserByEmail("mixed.case@example.com").ID)
+	assert.Equal(t, accountLimitKey(user.Email), accountLimitKey("mixed.case@example.com"))
+}
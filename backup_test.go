@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/benjamineskola/bookmarks/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackup(t *testing.T) {
+	t.Parallel()
+
+	if database.CurrentDialect() != database.SQLite {
+		t.Skip("backups are only supported for SQLite")
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.sqlite3")
+	assert.NoError(t, database.Backup(testStore.db, path))
+	assert.Error(t, database.Backup(testStore.db, path), "should not overwrite an existing backup")
+
+	status, err := database.GetMigrationStatus()
+	assert.NoError(t, err)
+
+	version, err := database.CheckBackup(path)
+	assert.NoError(t, err)
+	assert.Equal(t, status.Version, version)
+
+	_, err = database.CheckBackup(filepath.Join(t.TempDir(), "missing.sqlite3"))
+	assert.Error(t, err)
+}
+
+func TestRotateBackups(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for day := 0; day < 5; day++ {
+		name := database.BackupFileName(start.AddDate(0, 0, day))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+	}
+
+	removed, err := database.RotateBackups(dir, 2)
+	assert.NoError(t, err)
+	assert.Len(t, removed, 3)
+
+	remaining, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, []string{
+		filepath.Join(dir, database.BackupFileName(start.AddDate(0, 0, 3))),
+		filepath.Join(dir, database.BackupFileName(start.AddDate(0, 0, 4))),
+	}, remaining)
+}
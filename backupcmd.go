@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/benjamineskola/bookmarks/config"
+	"github.com/benjamineskola/bookmarks/database"
+	"gorm.io/gorm"
+)
+
+func backupCommand(args []string) error {
+	if err := database.Backup(openDatabase(), args[0]); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	log.Printf("backed up database to %s", args[0])
+
+	return nil
+}
+
+func restoreCommand(args []string) error {
+	version, err := database.CheckBackup(args[0])
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if err := database.Restore(args[0]); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	status, err := database.GetMigrationStatus()
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	log.Printf("restored database from %s (schema version %d, now %d)", args[0], version, status.Version)
+
+	return nil
+}
+
+// backupPeriodically takes a backup into the configured directory at each interval, keeping only the newest few.
+func backupPeriodically(ctx context.Context, db *gorm.DB, conf config.Backup) {
+	ticker := time.NewTicker(conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		path := filepath.Join(conf.Directory, database.BackupFileName(time.Now()))
+		err := database.Backup(db, path)
+		recordJob("backup", err)
+
+		if err != nil {
+			slog.Warn("scheduled backup failed", "error", err)
+
+			continue
+		}
+
+		removed, err := database.RotateBackups(conf.Directory, conf.Keep)
+		if err != nil {
+			slog.Warn("could not remove old backups", "error", err)
+		}
+
+		slog.Info("backed up database", "path", path, "removed", len(removed))
+	}
+}
@@ -17,9 +17,10 @@ TIMEZONE = ZoneInfo("Europe/London")
 
 def add_to_database(items: list[dict[str, Any]]) -> None:
     env = os.environ.get("ENVIRONMENT", "development")
-    cmd = ["bookmarks", "add"]
+    user = os.environ["BOOKMARKS_USER"]
+    cmd = ["bookmarks", "add", user]
     if env != "production":
-        cmd = ["go", "run", ".", "add"]
+        cmd = ["go", "run", ".", "add", user]
 
     process = subprocess.Popen(cmd, stdin=subprocess.PIPE, text=True)
     process.communicate(json.dumps(items))
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	errNoLinksSelected = errors.New("no links selected")
+	errNoBulkChange    = errors.New("no change given for the selected links")
+)
+
+// BulkChange is a change made to many links at once. Fields left as their zero value aren't changed.
+type BulkChange struct {
+	AddTags    []string
+	RemoveTags []string
+	Read       *bool
+	Public     *bool
+	// Delete moves the links to the trash, after making any other changes.
+	Delete bool
+}
+
+func (c BulkChange) isEmpty() bool {
+	return !hasTags(c.AddTags) && !hasTags(c.RemoveTags) && c.Read == nil && c.Public == nil && !c.Delete
+}
+
+// hasTags reports whether any of the strings names a tag, rather than being blank.
+func hasTags(tags []string) bool {
+	return slices.ContainsFunc(tags, func(tag string) bool { return len(NewTagListFromString(tag)) > 0 })
+}
+
+func (c BulkChange) apply(link *Link) {
+	if hasTags(c.AddTags) || hasTags(c.RemoveTags) {
+		tags := make(TagList)
+		if link.Tags != nil {
+			tags.Merge(*link.Tags)
+		}
+
+		for _, tag := range c.AddTags {
+			tags.Merge(NewTagListFromString(tag))
+		}
+
+		for _, tag := range c.RemoveTags {
+			for name := range NewTagListFromString(tag) {
+				delete(tags, name)
+			}
+		}
+
+		link.Tags = &tags
+	}
+
+	if c.Read != nil && *c.Read != link.IsRead() {
+		if *c.Read {
+			link.ReadAt = time.Now()
+		} else {
+			link.ReadAt = time.Time{}
+		}
+	}
+
+	setIfPresent(&link.Public, c.Public)
+}
+
+// uniqueIDs returns the IDs sorted and without duplicates.
+func uniqueIDs(ids []uint) []uint {
+	ids = slices.Clone(ids)
+	slices.Sort(ids)
+
+	return slices.Compact(ids)
+}
+
+func (s *GormStore) UpdateLinks(userID uint, ids []uint, change BulkChange, source RevisionSource) (int64, error) {
+	ids = uniqueIDs(ids)
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var links []Link
+		if err := tx.Where("user_id = ? and id in ?", userID, ids).Find(&links).Error; err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		if len(links) != len(ids) {
+			return errLinkNotFound
+		}
+
+		for i := range links {
+			change.apply(&links[i])
+
+			if err := saveWithRevision(tx, &links[i], source); err != nil {
+				return err
+			}
+		}
+
+		if !change.Delete {
+			return nil
+		}
+
+		return tx.Where("user_id = ? and id in ?", userID, ids).Delete(&Link{}).Error //nolint:exhaustruct,wrapcheck
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not update links: %w", err)
+	}
+
+	return int64(len(ids)), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// assetsVersion identifies the build and the templates and static files it serves, so that pages cached
+// before a deploy, with its old markup and CSRF token, aren't taken to be current after it.
+var assetsVersion = sync.OnceValue(func() string { //nolint:gochecknoglobals
+	hash := sha256.New()
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(hash, "%s\n", info.Main.Version)
+
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" || setting.Key == "vcs.modified" {
+				fmt.Fprintf(hash, "%s=%s\n", setting.Key, setting.Value)
+			}
+		}
+	}
+
+	for _, dir := range []string{"templates", "static"} {
+		_ = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return nil //nolint:nilerr
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil //nolint:nilerr
+			}
+
+			fmt.Fprintf(hash, "%s\n%x\n", path, sha256.Sum256(content))
+
+			return nil
+		})
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil))
+})
+
+// cacheLinks sets the validators and caching policy for a view of the owner's links, and responds with 304 Not
+// Modified if the client's copy is still current, reporting whether it did. This saves loading and rendering
+// the links for clients, such as feed readers, which poll an index that rarely changes.
+func (app *App) cacheLinks(w http.ResponseWriter, r *http.Request, ownerID uint) bool {
+	// the validators cover all of the owner's links, since a link can leave a view without changing in a way
+	// the view's filter can see, such as by being deleted, or made private when the view is public
+	lastModified, count := app.links(r).GetLastModified(ownerID)
+
+	// the viewer and the URL are included because the same links are shown differently to each, and the
+	// version of the assets because a deploy can change how they are shown
+	var viewerID uint
+	if user := currentUser(r); user != nil {
+		viewerID = user.ID
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\n%d\n%d\n%s\n%s", lastModified.UnixNano(), count, viewerID, r.URL.RequestURI(),
+		assetsVersion())
+	// weak, since the page's CSRF token changes each time
+	etag := fmt.Sprintf(`W/"%x"`, hash.Sum(nil)[:16])
+
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Cookie, Authorization")
+
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if isAuthenticated(r) {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, no-cache")
+	}
+
+	if !isCurrent(r, etag, lastModified) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+
+	return true
+}
+
+// isCurrent checks the request's If-None-Match header against the ETag or, if there isn't one, its
+// If-Modified-Since header against the last modified time.
+func isCurrent(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || lastModified.IsZero() {
+		return false
+	}
+
+	return !lastModified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const programName = "bookmarks"
+
+var errUsage = errors.New("wrong arguments")
+
+// command is a subcommand of the bookmarks binary.
+type command struct {
+	name string
+	// args describes the positional arguments, for the usage message
+	args    string
+	summary string
+	// setup registers the command's flags, and returns the function that runs it with the remaining arguments.
+	// The function returns errUsage if the arguments are wrong.
+	setup func(flags *flag.FlagSet) func(args []string) error
+	// subcommands, such as "user add", are chosen by the next argument. If the command also has a setup, it is
+	// what runs when there is no next argument.
+	subcommands []command
+}
+
+func (c command) printUsage(output io.Writer, parent string, flags *flag.FlagSet) {
+	fmt.Fprintf(output, "usage: %s %s", parent, c.name)
+
+	hasFlags := false
+	flags.VisitAll(func(*flag.Flag) { hasFlags = true })
+
+	if hasFlags {
+		fmt.Fprint(output, " [flags]")
+	}
+
+	if c.args != "" {
+		fmt.Fprintf(output, " %s", c.args)
+	}
+
+	fmt.Fprintf(output, "\n\n%s\n", c.summary)
+
+	if hasFlags {
+		fmt.Fprint(output, "\nflags:\n")
+		flags.SetOutput(output)
+		flags.PrintDefaults()
+	}
+}
+
+// exactArgs wraps a command which takes a fixed number of arguments.
+func exactArgs(count int, run func(args []string) error) func(args []string) error {
+	return rangeArgs(count, count, run)
+}
+
+// rangeArgs wraps a command which takes between least and most arguments, or at least least if most is negative.
+func rangeArgs(least int, most int, run func(args []string) error) func(args []string) error {
+	return func(args []string) error {
+		if len(args) < least || (most >= 0 && len(args) > most) {
+			return errUsage
+		}
+
+		return run(args)
+	}
+}
+
+// withoutFlags wraps a command which has no flags of its own.
+func withoutFlags(run func(args []string) error) func(*flag.FlagSet) func(args []string) error {
+	return func(*flag.FlagSet) func(args []string) error {
+		return run
+	}
+}
+
+// printCommands lists the commands; parent is how they are run, such as "bookmarks" or "bookmarks user".
+func printCommands(output io.Writer, parent string, commands []command) {
+	if parent == programName {
+		fmt.Fprintf(output, "usage: %s [flags] COMMAND [ARGS]\n\ncommands:\n", parent)
+	} else {
+		fmt.Fprintf(output, "usage: %s COMMAND [ARGS]\n\ncommands:\n", parent)
+	}
+
+	width := 0
+	for _, c := range commands {
+		width = max(width, len(c.name))
+	}
+
+	for _, c := range commands {
+		fmt.Fprintf(output, "  %-*s  %s\n", width, c.name, c.summary)
+	}
+
+	fmt.Fprintf(output, "\nRun \"%s COMMAND --help\" for more about a command.\n", parent)
+}
+
+// runCommand runs the command named by the first argument, one of those run as parent, and returns the exit status.
+func runCommand(parent string, commands []command, args []string) int {
+	if len(args) == 0 {
+		printCommands(os.Stderr, parent, commands)
+
+		return 2 //nolint:gomnd
+	}
+
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		if len(args) == 2 { //nolint:gomnd
+			return runCommand(parent, commands, []string{args[1], "--help"})
+		}
+
+		printCommands(os.Stdout, parent, commands)
+
+		return 0
+	}
+
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+
+		if c.subcommands != nil && (len(args) > 1 || c.setup == nil) {
+			return runCommand(parent+" "+c.name, c.subcommands, args[1:])
+		}
+
+		flags := flag.NewFlagSet(c.name, flag.ContinueOnError)
+		run := c.setup(flags)
+		flags.Usage = func() {}
+
+		positional, err := parseInterspersed(flags, args[1:])
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				c.printUsage(os.Stdout, parent, flags)
+
+				return 0
+			}
+
+			c.printUsage(os.Stderr, parent, flags)
+
+			return 2 //nolint:gomnd
+		}
+
+		if err := run(positional); err != nil {
+			if errors.Is(err, errUsage) {
+				c.printUsage(os.Stderr, parent, flags)
+
+				return 2 //nolint:gomnd
+			}
+
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", parent, c.name, err)
+
+			return 1
+		}
+
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: unknown command %q\n\n", parent, args[0])
+	printCommands(os.Stderr, parent, commands)
+
+	return 2 //nolint:gomnd
+}
+
+// parseInterspersed parses flags wherever they appear among the arguments, so that "save URL --public" works,
+// and returns the rest; everything after "--" is left alone.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0, len(args))
+
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		rest := flags.Args()
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+
+		if len(rest) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInterspersed(t *testing.T) {
+	t.Parallel()
+
+	flags := flag.NewFlagSet("save", flag.ContinueOnError)
+	title := flags.String("title", "", "")
+	public := flags.Bool("public", false, "")
+
+	args, err := parseInterspersed(flags, []string{"https://example.com", "--title", "Example", "--public", "--", "-x"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://example.com", "-x"}, args)
+	assert.Equal(t, "Example", *title)
+	assert.True(t, *public)
+}
+
+func TestWriteLinks(t *testing.T) {
+	t.Parallel()
+
+	link := NewLink(1, "https://example.com/a", "Tab\there", "", true)
+	link.ID = 7
+	link.SavedAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tags := NewTagListFromString("b,a")
+	link.Tags = &tags
+
+	var output bytes.Buffer
+	require.NoError(t, writeLinks(&output, "tsv", []Link{*link}))
+	assert.Equal(t, "id\tsaved\tread\tpublic\turl\ttitle\ttags\n"+
+		"7\t2024-05-01\t-\ttrue\thttps://example.com/a\tTab here\ta,b\n", output.String())
+
+	require.ErrorIs(t, writeLinks(&output, "xml", nil), errUnknownFormat)
+}
+
+func TestRunSubcommands(t *testing.T) {
+	t.Parallel()
+
+	var ran []string
+
+	record := func(name string) func(*flag.FlagSet) func([]string) error {
+		return withoutFlags(rangeArgs(0, 1, func(args []string) error {
+			ran = append(ran, name+" "+strings.Join(args, " "))
+
+			return nil
+		}))
+	}
+	commands := []command{
+		{name: "parent", args: "", summary: "", setup: record("parent"), subcommands: []command{
+			{name: "child", args: "[X]", summary: "", setup: record("child"), subcommands: nil},
+		}},
+		{name: "group", args: "", summary: "", setup: nil, subcommands: []command{
+			{name: "child", args: "", summary: "", setup: record("group child"), subcommands: nil},
+		}},
+	}
+
+	assert.Equal(t, 0, runCommand("test", commands, []string{"parent"}))
+	assert.Equal(t, 0, runCommand("test", commands, []string{"parent", "child", "x"}))
+	assert.Equal(t, 0, runCommand("test", commands, []string{"group", "child"}))
+	assert.Equal(t, []string{"parent ", "child x", "group child "}, ran)
+
+	assert.Equal(t, 0, runCommand("test", commands, []string{"group", "--help"}))
+	assert.Equal(t, 0, runCommand("test", commands, []string{"parent", "child", "--help"}))
+	assert.Equal(t, 2, runCommand("test", commands, []string{"group"}))
+	assert.Equal(t, 2, runCommand("test", commands, []string{"group", "unknown"}))
+	assert.Equal(t, 2, runCommand("test", commands, []string{"parent", "child", "x", "y"}))
+	assert.Len(t, ran, 3)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/benjamineskola/bookmarks/config"
+)
+
+const clientTimeout = 30 * time.Second
+
+var (
+	errServer      = errors.New("server error")
+	errUserOnLocal = errors.New("the user can only be chosen for the local database; the server uses the API token's")
+)
+
+// remoteLinks works with the links of the API token's owner on a running server, through its JSON API.
+type remoteLinks struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+func newRemoteLinks(server string, token string) *remoteLinks {
+	return &remoteLinks{
+		server: strings.TrimRight(server, "/"),
+		token:  token,
+		client: &http.Client{Timeout: clientTimeout}, //nolint:exhaustruct
+	}
+}
+
+// do sends body, if there is one, as JSON and decodes the JSON response into result.
+func (c *remoteLinks) do(method string, path string, query url.Values, body any, result any) error {
+	var content io.Reader
+
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+
+		content = bytes.NewReader(encoded)
+	}
+
+	target := c.server + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, target, content) //nolint:noctx
+	if err != nil {
+		return fmt.Errorf("could not make request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("could not read response from server: %w", err)
+		}
+
+		return nil
+	case http.StatusNotFound:
+		return errLinkNotFound
+	case http.StatusConflict:
+		return errLinkTrashed
+	case http.StatusUnauthorized:
+		return errInvalidAPIToken
+	default:
+		var failure struct{ Message string }
+
+		_ = json.NewDecoder(resp.Body).Decode(&failure)
+
+		return fmt.Errorf("%w: %s: %s", errServer, resp.Status, failure.Message)
+	}
+}
+
+func (c *remoteLinks) ListLinks(filter LinkFilter, limit int) ([]Link, error) {
+	query := url.Values{"order": {"desc"}, "per_page": {strconv.Itoa(config.MaxPageSize)}}
+	if filter.Ascending {
+		query.Set("order", "asc")
+	}
+
+	for key, value := range map[string]string{
+		"sort": filter.Sort, "tag": filter.Tag, "q": filter.Search, "url": filter.URL,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	// given as parameters rather than by path, the filters can be combined as they can locally
+	for key, selected := range map[string]bool{
+		"read": filter.OnlyRead, "unread": filter.OnlyUnread, "public": filter.OnlyPublic,
+	} {
+		if selected {
+			query.Set(key, "true")
+		}
+	}
+
+	result := make([]Link, 0)
+	page := 1
+
+	// follow the cursors where the server gives them, so that links saved meanwhile don't shift the pages
+	for limit == 0 || len(result) < limit {
+		var links linkPage
+		if err := c.do(http.MethodGet, fmt.Sprintf("/links/page/%d.json", page), query, nil, &links); err != nil {
+			return nil, err
+		}
+
+		result = append(result, *links.Links...)
+
+		if links.Next != "" {
+			query.Set("cursor", links.Next)
+
+			continue
+		}
+
+		if len(*links.Links) == 0 || query.Has("cursor") || int64(page*links.PerPage) >= links.Total {
+			break
+		}
+
+		page++
+	}
+
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+func (c *remoteLinks) GetLinkByID(id uint) (*Link, error) {
+	var link Link
+	if err := c.do(http.MethodGet, fmt.Sprintf("/links/%d.json", id), nil, nil, &link); err != nil {
+		return nil, err
+	}
+
+	return &link, nil
+}
+
+func (c *remoteLinks) GetLinkByURL(url string) (*Link, error) {
+	links, err := c.ListLinks(LinkFilter{URL: url}, 1) //nolint:exhaustruct
+	if err != nil {
+		return nil, err
+	}
+
+	if len(links) == 0 {
+		return nil, errLinkNotFound
+	}
+
+	return &links[0], nil
+}
+
+// SaveLink saves the link on the server, which records the change as coming from the API whatever the source.
+func (c *remoteLinks) SaveLink(link *Link, _ RevisionSource) error {
+	path := "/links.json"
+	if link.ID != 0 {
+		path = fmt.Sprintf("/links/%d.json", link.ID)
+	}
+
+	return c.do(http.MethodPost, path, nil, link, link)
+}
+
+func (c *remoteLinks) DeleteLink(id uint) error {
+	var result map[string]string
+
+	return c.do(http.MethodDelete, fmt.Sprintf("/links/%d.json", id), nil, nil, &result)
+}
+
+func (c *remoteLinks) UpdateLinks(ids []uint, change BulkChange) (int64, error) {
+	var result struct{ Links int64 }
+
+	err := c.do(http.MethodPost, "/links/bulk.json", nil, bulkInput{IDs: ids, BulkChange: change}, &result)
+
+	return result.Links, err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteLinks(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+
+	token, err := user.ResetAPIToken()
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateUser(user, "api_token_hash"))
+
+	links := newRemoteLinks(client.server.URL, token)
+
+	tags := NewTagListFromString("go")
+	link := NewLink(0, "https://example.com/remote", "Remote", "", true)
+	link.Tags = &tags
+	require.NoError(t, links.SaveLink(link, RevisionSourceCLI))
+	assert.NotZero(t, link.ID)
+	assert.False(t, link.SavedAt.IsZero())
+
+	saved, err := store.GetLinkByID(user.ID, link.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Remote", saved.Title)
+	assert.Equal(t, []string{"go"}, saved.Tags.Sorted())
+	assert.Equal(t, RevisionSourceAPI, store.GetLinkRevisions(link.ID)[0].Source)
+
+	_, err = store.SaveLink(NewLink(user.ID, "https://example.com/other", "Other", "", false), RevisionSourceCLI)
+	require.NoError(t, err)
+
+	found, err := links.GetLinkByURL("https://example.com/remote")
+	require.NoError(t, err)
+	assert.Equal(t, link.ID, found.ID)
+
+	_, err = links.GetLinkByURL("https://example.com/missing")
+	require.ErrorIs(t, err, errLinkNotFound)
+
+	all, err := links.ListLinks(LinkFilter{}, 0) //nolint:exhaustruct
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	matching, err := links.ListLinks(LinkFilter{Search: "remote", Tag: "go"}, 0) //nolint:exhaustruct
+	require.NoError(t, err)
+	assert.Len(t, matching, 1)
+
+	found.ReadAt = time.Now()
+	require.NoError(t, links.SaveLink(found, RevisionSourceCLI))
+
+	read, err := links.ListLinks(LinkFilter{OnlyRead: true}, 0) //nolint:exhaustruct
+	require.NoError(t, err)
+	assert.Len(t, read, 1)
+
+	// combined, as they can be locally
+	readPublic, err := links.ListLinks(LinkFilter{OnlyRead: true, OnlyPublic: true}, 0) //nolint:exhaustruct
+	require.NoError(t, err)
+	assert.Len(t, readPublic, 1)
+
+	unreadPublic, err := links.ListLinks(LinkFilter{OnlyUnread: true, OnlyPublic: true}, 0) //nolint:exhaustruct
+	require.NoError(t, err)
+	assert.Empty(t, unreadPublic)
+
+	count, err := links.UpdateLinks([]uint{link.ID, found.ID}, BulkChange{AddTags: []string{"bulk"}}) //nolint:exhaustruct
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	saved, err = store.GetLinkByID(user.ID, link.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bulk", "go"}, saved.Tags.Sorted())
+
+	require.NoError(t, links.DeleteLink(link.ID))
+	require.ErrorIs(t, links.DeleteLink(link.ID), errLinkNotFound)
+	require.ErrorIs(t, links.SaveLink(NewLink(0, "https://example.com/remote", "", "", false), RevisionSourceCLI),
+		errLinkTrashed)
+
+	_, err = newRemoteLinks(client.server.URL, "wrong").ListLinks(LinkFilter{}, 0) //nolint:exhaustruct
+	require.ErrorIs(t, err, errInvalidAPIToken)
+}
+
+func TestImportLinks(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryStore()
+
+	user, err := NewUser("importer", "importer@example.com", "password")
+	require.NoError(t, err)
+	require.NoError(t, store.CreateUser(user))
+
+	links := localLinks{store: store, user: user}
+	input := `[{"URL": "https://example.com/a", "Title": "A", "Tags": "x,y"}, {"Title": "no URL"}]`
+
+	require.ErrorIs(t, importLinks(links, strings.NewReader(input)), errImportFailed)
+
+	link, err := store.GetLinkByURL(user.ID, "https://example.com/a")
+	require.NoError(t, err)
+	assert.Equal(t, "A", link.Title)
+	assert.Equal(t, []string{"x", "y"}, link.Tags.Sorted())
+	assert.False(t, link.SavedAt.IsZero())
+}
+
+func TestImportLinksMalformedURL(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryStore()
+
+	user, err := NewUser("importer", "importer@example.com", "password")
+	require.NoError(t, err)
+	require.NoError(t, store.CreateUser(user))
+
+	links := localLinks{store: store, user: user}
+	input := `[{"URL": "https://example.com/before"}, {"URL": "http://[::1"}, {"URL": "https://example.com/after"}]`
+
+	require.ErrorContains(t, importLinks(links, strings.NewReader(input)), "1 rows")
+
+	for _, url := range []string{"https://example.com/before", "https://example.com/after"} {
+		_, err := store.GetLinkByURL(user.ID, url)
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, store.links, 2)
+}
@@ -1,12 +1,34 @@
 package config
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+
+	csrfKeyLength      = 32
+	minSecretKeyLength = 32
+	redacted           = "[redacted]"
+)
+
+var (
+	errUnknownKeys   = errors.New("unknown settings")
+	errInvalidConfig = errors.New("invalid configuration")
+)
+
 type URLNormalisations struct {
 	AddWWW        []string          `toml:"add-www"`
 	RemoveWWW     []string          `toml:"remove-www"`
@@ -14,29 +36,114 @@ type URLNormalisations struct {
 	ForceHTTPS    []string          `toml:"force-https"`
 }
 
-type ConfigType struct { //nolint:revive
-	URLNormalisations URLNormalisations `toml:"UrlNormalisations"`
+type Server struct {
+	Host      string `toml:"host"`
+	Port      int    `toml:"port"`
+	CSRFKey   string `toml:"csrf-key"`
+	SecretKey string `toml:"secret-key"`
+	// ShutdownTimeout is how long in-flight requests have to finish when the server is stopped.
+	ShutdownTimeout time.Duration `toml:"shutdown-timeout"`
+	// TrustedProxies lists the IP addresses or CIDR ranges of reverse proxies whose X-Forwarded-For header
+	// gives the client's address.
+	TrustedProxies []string `toml:"trusted-proxies"`
 }
 
-var Config ConfigType //nolint:gochecknoglobals
+type Database struct {
+	URL         string `toml:"url"`
+	AutoMigrate bool   `toml:"auto-migrate"`
+}
 
-func LoadConfig() {
-	configPath := "config.toml"
+type Session struct {
+	IdleTimeout time.Duration `toml:"idle-timeout"`
+	MaxAge      time.Duration `toml:"max-age"`
+}
 
-	configFile, err := os.Open(configPath)
-	if err != nil {
-		return
-	}
+type Pagination struct {
+	PageSize int `toml:"page-size"`
+}
 
-	if _, err := toml.NewDecoder(configFile).Decode(&Config); err != nil {
-		log.Printf("error decoding TOML: %s", err)
+type Features struct {
+	// TrashRetention is how long deleted links stay in the trash before they are purged.
+	TrashRetention time.Duration `toml:"trash-retention"`
+	// PublicProfiles serves each user's public links at /u/{name}/links/public.
+	PublicProfiles bool `toml:"public-profiles"`
+}
 
-		return
-	}
+type Log struct {
+	// Format is "text" or "json".
+	Format string `toml:"format"`
+	Level  string `toml:"level"`
+	// SlowQuery logs database queries that take longer than this; 0 turns it off.
+	SlowQuery time.Duration `toml:"slow-query"`
+}
+
+// Metrics controls who can read /metrics: clients from an allowed address, or presenting the token.
+type Metrics struct {
+	// Allow lists IP addresses or CIDR ranges. It is empty by default, since behind a reverse proxy that isn't
+	// in server.trusted-proxies every request seems to come from the proxy's address.
+	Allow []string `toml:"allow"`
+	Token string   `toml:"token"`
+}
+
+// Backup configures scheduled backups; they are disabled unless both Directory and Interval are set.
+type Backup struct {
+	Directory string        `toml:"directory"`
+	Interval  time.Duration `toml:"interval"`
+	Keep      int           `toml:"keep"`
+}
+
+// Client points the command-line link commands at a running server instead of the local database.
+type Client struct {
+	// Server is the server's base URL, such as https://bookmarks.example.com; the commands use the local database
+	// if it's empty.
+	Server string `toml:"server"`
+	// Token is an API token from "bookmarks user token".
+	Token string `toml:"token"`
+}
+
+type ConfigType struct { //nolint:revive
+	// Environment names the default SQLite database, data/{Environment}.sqlite3.
+	Environment       string            `toml:"environment"`
+	Server            Server            `toml:"server"`
+	Database          Database          `toml:"database"`
+	Session           Session           `toml:"session"`
+	Pagination        Pagination        `toml:"pagination"`
+	Features          Features          `toml:"features"`
+	Log               Log               `toml:"log"`
+	Metrics           Metrics           `toml:"metrics"`
+	Backup            Backup            `toml:"backup"`
+	Client            Client            `toml:"client"`
+	URLNormalisations URLNormalisations `toml:"urlNormalisations"`
 }
 
+var Config ConfigType //nolint:gochecknoglobals
+
+// MakeConfig returns the default configuration.
 func MakeConfig() ConfigType {
 	conf := ConfigType{
+		Environment: "development",
+		Server: Server{
+			Host:            "0.0.0.0",
+			Port:            8080, //nolint:gomnd
+			CSRFKey:         "",
+			SecretKey:       "",
+			ShutdownTimeout: 30 * time.Second, //nolint:gomnd
+			TrustedProxies:  make([]string, 0),
+		},
+		Database: Database{URL: "", AutoMigrate: false},
+		Session: Session{
+			IdleTimeout: 7 * 24 * time.Hour,  //nolint:gomnd
+			MaxAge:      30 * 24 * time.Hour, //nolint:gomnd
+		},
+		Pagination: Pagination{PageSize: DefaultPageSize},
+		Features: Features{
+			TrashRetention: 30 * 24 * time.Hour, //nolint:gomnd
+			PublicProfiles: true,
+		},
+		Log:     Log{Format: "text", Level: "info", SlowQuery: 200 * time.Millisecond}, //nolint:gomnd
+		Metrics: Metrics{Allow: make([]string, 0), Token: ""},
+		Backup:  Backup{Directory: "", Interval: 0, Keep: 7}, //nolint:gomnd
+		Client:  Client{Server: "", Token: ""},
 		URLNormalisations: URLNormalisations{
 			AddWWW:        make([]string, 0),
 			RemoveWWW:     make([]string, 0),
@@ -47,3 +154,310 @@ func MakeConfig() ConfigType {
 
 	return conf
 }
+
+// setting is a single value that can be overridden by an environment variable or a flag,
+// which is named after its key in config.toml.
+type setting struct {
+	name  string
+	env   string
+	usage string
+	field func(conf *ConfigType) any
+}
+
+var settings = []setting{ //nolint:gochecknoglobals
+	{"environment", "ENVIRONMENT", "name of the default database",
+		func(c *ConfigType) any { return &c.Environment }},
+	{"server.host", "HOST", "address to listen on",
+		func(c *ConfigType) any { return &c.Server.Host }},
+	{"server.port", "PORT", "port to listen on",
+		func(c *ConfigType) any { return &c.Server.Port }},
+	{"server.csrf-key", "CSRF_KEY", "32-byte key for CSRF tokens",
+		func(c *ConfigType) any { return &c.Server.CSRFKey }},
+	{"server.secret-key", "SECRET_KEY", "key of at least 32 bytes for signing cookies",
+		func(c *ConfigType) any { return &c.Server.SecretKey }},
+	{"server.shutdown-timeout", "SHUTDOWN_TIMEOUT", "how long to wait for requests to finish when stopping",
+		func(c *ConfigType) any { return &c.Server.ShutdownTimeout }},
+	{"server.trusted-proxies", "TRUSTED_PROXIES", "comma-separated addresses of reverse proxies to trust",
+		func(c *ConfigType) any { return &c.Server.TrustedProxies }},
+	{"database.url", "DATABASE_URL", "SQLite path or PostgreSQL URL",
+		func(c *ConfigType) any { return &c.Database.URL }},
+	{"database.auto-migrate", "AUTO_MIGRATE", "apply migrations when the server starts",
+		func(c *ConfigType) any { return &c.Database.AutoMigrate }},
+	{"session.idle-timeout", "SESSION_IDLE_TIMEOUT", "how long an unused session lasts",
+		func(c *ConfigType) any { return &c.Session.IdleTimeout }},
+	{"session.max-age", "SESSION_MAX_AGE", "how long any session lasts",
+		func(c *ConfigType) any { return &c.Session.MaxAge }},
+	{"pagination.page-size", "PAGE_SIZE", "links per page",
+		func(c *ConfigType) any { return &c.Pagination.PageSize }},
+	{"features.trash-retention", "TRASH_RETENTION", "how long deleted links are kept",
+		func(c *ConfigType) any { return &c.Features.TrashRetention }},
+	{"features.public-profiles", "PUBLIC_PROFILES", "serve public links at /u/{name}",
+		func(c *ConfigType) any { return &c.Features.PublicProfiles }},
+	{"log.format", "LOG_FORMAT", "text or json",
+		func(c *ConfigType) any { return &c.Log.Format }},
+	{"log.level", "LOG_LEVEL", "debug, info, warn or error",
+		func(c *ConfigType) any { return &c.Log.Level }},
+	{"log.slow-query", "LOG_SLOW_QUERY", "log database queries slower than this",
+		func(c *ConfigType) any { return &c.Log.SlowQuery }},
+	{"metrics.allow", "METRICS_ALLOW", "comma-separated addresses allowed to read /metrics",
+		func(c *ConfigType) any { return &c.Metrics.Allow }},
+	{"metrics.token", "METRICS_TOKEN", "bearer token for reading /metrics",
+		func(c *ConfigType) any { return &c.Metrics.Token }},
+	{"backup.directory", "BACKUP_DIRECTORY", "directory for scheduled backups",
+		func(c *ConfigType) any { return &c.Backup.Directory }},
+	{"backup.interval", "BACKUP_INTERVAL", "time between scheduled backups",
+		func(c *ConfigType) any { return &c.Backup.Interval }},
+	{"backup.keep", "BACKUP_KEEP", "number of scheduled backups to keep",
+		func(c *ConfigType) any { return &c.Backup.Keep }},
+	{"client.server", "BOOKMARKS_SERVER", "URL of the server for the link commands to use instead of the database",
+		func(c *ConfigType) any { return &c.Client.Server }},
+	{"client.token", "BOOKMARKS_TOKEN", "API token for client.server",
+		func(c *ConfigType) any { return &c.Client.Token }},
+}
+
+func (s setting) set(conf *ConfigType, value string) error {
+	var err error
+
+	switch field := s.field(conf).(type) {
+	case *string:
+		*field = value
+	case *int:
+		*field, err = strconv.Atoi(value)
+	case *bool:
+		*field, err = strconv.ParseBool(value)
+	case *time.Duration:
+		*field, err = time.ParseDuration(value)
+	case *[]string:
+		*field = make([]string, 0)
+
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field = append(*field, item)
+			}
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid value %q", value)
+	}
+
+	return nil
+}
+
+// Loader builds the configuration from the defaults, then the config file, then the environment, then flags.
+type Loader struct {
+	path         string
+	pathExplicit bool
+	flags        map[string]string
+}
+
+// RegisterFlags adds --config and a flag for each setting to the flag set.
+func RegisterFlags(flags *flag.FlagSet) *Loader {
+	loader := &Loader{path: "config.toml", pathExplicit: false, flags: make(map[string]string)}
+
+	flags.Func("config", "path to the configuration file (default config.toml)", func(value string) error {
+		loader.path = value
+		loader.pathExplicit = true
+
+		return nil
+	})
+
+	for _, s := range settings {
+		name := s.name
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.env)
+		record := func(value string) error {
+			loader.flags[name] = value
+
+			return nil
+		}
+
+		if _, ok := s.field(&ConfigType{}).(*bool); ok { //nolint:exhaustruct
+			flags.BoolFunc(name, usage, record)
+		} else {
+			flags.Func(name, usage, record)
+		}
+	}
+
+	return loader
+}
+
+// Load returns the effective configuration, or an error describing every problem with it.
+func (l *Loader) Load() (ConfigType, error) {
+	conf := MakeConfig()
+
+	if err := conf.loadFile(l.path); err != nil {
+		if l.pathExplicit || !errors.Is(err, os.ErrNotExist) {
+			return conf, err
+		}
+	}
+
+	problems := make([]error, 0)
+
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			if err := s.set(&conf, value); err != nil {
+				problems = append(problems, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := l.flags[s.name]; ok {
+			if err := s.set(&conf, value); err != nil {
+				problems = append(problems, fmt.Errorf("--%s: %w", s.name, err))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return conf, fmt.Errorf("%w:\n%w", errInvalidConfig, errors.Join(problems...))
+	}
+
+	return conf, conf.Validate()
+}
+
+func (c *ConfigType) loadFile(path string) error {
+	meta, err := toml.DecodeFile(path, c)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, 0, len(undecoded))
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+
+		return fmt.Errorf("%s: %w: %s", path, errUnknownKeys, strings.Join(keys, ", "))
+	}
+
+	return nil
+}
+
+// Validate checks every setting which has a value, reporting all of the problems at once.
+func (c ConfigType) Validate() error {
+	problems := make([]error, 0)
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Errorf(format, args...)) //nolint:goerr113
+		}
+	}
+
+	check(c.Environment != "", "environment must not be empty")
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port must be between 1 and 65535")
+	check(c.Server.CSRFKey == "" || len(c.Server.CSRFKey) == csrfKeyLength,
+		"server.csrf-key must be %d bytes", csrfKeyLength)
+	check(c.Server.SecretKey == "" || len(c.Server.SecretKey) >= minSecretKeyLength,
+		"server.secret-key must be at least %d bytes", minSecretKeyLength)
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown-timeout must be positive")
+	check(c.Session.IdleTimeout > 0, "session.idle-timeout must be positive")
+	check(c.Session.MaxAge >= c.Session.IdleTimeout, "session.max-age must be at least session.idle-timeout")
+	check(c.Pagination.PageSize > 0 && c.Pagination.PageSize <= MaxPageSize,
+		"pagination.page-size must be between 1 and %d", MaxPageSize)
+	check(c.Features.TrashRetention > 0, "features.trash-retention must be positive")
+	check(c.Backup.Interval >= 0, "backup.interval must not be negative")
+	check(c.Backup.Keep > 0, "backup.keep must be at least 1")
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json")
+	check(slices.Contains([]string{"debug", "info", "warn", "error"}, strings.ToLower(c.Log.Level)),
+		"log.level must be debug, info, warn or error")
+	check(c.Log.SlowQuery >= 0, "log.slow-query must not be negative")
+
+	for _, proxy := range c.Server.TrustedProxies {
+		check(parseNetwork(proxy) != nil, "server.trusted-proxies: %q is not an IP address or CIDR range", proxy)
+	}
+
+	for _, allowed := range c.Metrics.Allow {
+		check(parseNetwork(allowed) != nil, "metrics.allow: %q is not an IP address or CIDR range", allowed)
+	}
+
+	if c.Client.Server != "" {
+		server, err := url.Parse(c.Client.Server)
+		check(err == nil && (server.Scheme == "http" || server.Scheme == "https") && server.Host != "",
+			"client.server must be an http or https URL")
+		check(c.Client.Token != "", "client.token must be set when client.server is")
+	}
+
+	problems = append(problems, c.URLNormalisations.Validate()...)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w:\n%w", errInvalidConfig, errors.Join(problems...))
+	}
+
+	return nil
+}
+
+// ValidateServer additionally requires the settings that only the server needs.
+func (c ConfigType) ValidateServer() error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	problems := make([]error, 0)
+
+	if c.Server.CSRFKey == "" {
+		problems = append(problems, errors.New("server.csrf-key must be set")) //nolint:goerr113
+	}
+
+	if c.Server.SecretKey == "" {
+		problems = append(problems, errors.New("server.secret-key must be set")) //nolint:goerr113
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w:\n%w", errInvalidConfig, errors.Join(problems...))
+	}
+
+	return nil
+}
+
+// Redacted returns a copy of the configuration that is safe to print.
+func (c ConfigType) Redacted() ConfigType {
+	for _, secret := range []*string{&c.Server.CSRFKey, &c.Server.SecretKey, &c.Metrics.Token, &c.Client.Token} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+
+	if dbURL, err := url.Parse(c.Database.URL); err == nil {
+		c.Database.URL = dbURL.Redacted()
+	}
+
+	return c
+}
+
+func parseNetwork(value string) *net.IPNet {
+	if _, network, err := net.ParseCIDR(value); err == nil {
+		return network
+	}
+
+	if ip := net.ParseIP(value); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}
+	}
+
+	return nil
+}
+
+func parseNetworks(values []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(values))
+
+	for _, value := range values {
+		if network := parseNetwork(value); network != nil {
+			networks = append(networks, network)
+		}
+	}
+
+	return networks
+}
+
+// AllowedNetworks returns the parsed metrics.allow list.
+func (m Metrics) AllowedNetworks() []*net.IPNet {
+	return parseNetworks(m.Allow)
+}
+
+// TrustedNetworks returns the parsed server.trusted-proxies list.
+func (s Server) TrustedNetworks() []*net.IPNet {
+	return parseNetworks(s.TrustedProxies)
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var errInvalidHost = errors.New("must be a bare host name")
+
+// the URL normalisation rules can be reloaded while the server is running, so they are kept apart from Config
+var currentNormalisations atomic.Pointer[URLNormalisations] //nolint:gochecknoglobals
+
+// Normalisations returns the URL normalisation rules currently in effect. The result must not be modified.
+func Normalisations() *URLNormalisations {
+	if rules := currentNormalisations.Load(); rules != nil {
+		return rules
+	}
+
+	defaults := MakeConfig()
+
+	return &defaults.URLNormalisations
+}
+
+// Current returns a copy of Config with the URL normalisation rules currently in effect, rather than those it was
+// loaded with, which a reload may have replaced.
+func Current() ConfigType {
+	conf := Config
+	conf.URLNormalisations = *Normalisations()
+
+	return conf
+}
+
+// SetNormalisations replaces the URL normalisation rules in a single step.
+func SetNormalisations(rules URLNormalisations) {
+	currentNormalisations.Store(&rules)
+}
+
+func checkHosts(key string, hosts []string) []error {
+	problems := make([]error, 0)
+
+	for _, host := range hosts {
+		if host == "" || strings.ContainsAny(host, ":/ ") || host != strings.ToLower(host) {
+			problems = append(problems, fmt.Errorf("urlNormalisations.%s: %q %w", key, host, errInvalidHost))
+		}
+	}
+
+	return problems
+}
+
+// Validate checks that every rule names a lower-case host without a scheme or path.
+func (n URLNormalisations) Validate() []error {
+	problems := checkHosts("add-www", n.AddWWW)
+	problems = append(problems, checkHosts("remove-www", n.RemoveWWW)...)
+	problems = append(problems, checkHosts("force-https", n.ForceHTTPS)...)
+
+	for from, to := range n.ReplaceDomain {
+		problems = append(problems, checkHosts("replace-domain", []string{from, to})...)
+	}
+
+	for _, host := range n.AddWWW {
+		if slices.Contains(n.RemoveWWW, "www."+host) {
+			problems = append(problems, fmt.Errorf( //nolint:goerr113
+				"urlNormalisations: %q is in add-www but www.%s is in remove-www", host, host))
+		}
+	}
+
+	return problems
+}
+
+func diffHosts(key string, before []string, after []string) []string {
+	changes := make([]string, 0)
+
+	for _, host := range after {
+		if !slices.Contains(before, host) {
+			changes = append(changes, fmt.Sprintf("%s: +%s", key, host))
+		}
+	}
+
+	for _, host := range before {
+		if !slices.Contains(after, host) {
+			changes = append(changes, fmt.Sprintf("%s: -%s", key, host))
+		}
+	}
+
+	return changes
+}
+
+// Diff describes the rules that were added or removed going from n to other, one change per line.
+func (n URLNormalisations) Diff(other URLNormalisations) []string {
+	changes := diffHosts("add-www", n.AddWWW, other.AddWWW)
+	changes = append(changes, diffHosts("remove-www", n.RemoveWWW, other.RemoveWWW)...)
+	changes = append(changes, diffHosts("force-https", n.ForceHTTPS, other.ForceHTTPS)...)
+
+	hosts := make([]string, 0, len(n.ReplaceDomain))
+	for host := range n.ReplaceDomain {
+		hosts = append(hosts, host)
+	}
+
+	for host := range other.ReplaceDomain {
+		if _, ok := n.ReplaceDomain[host]; !ok {
+			hosts = append(hosts, host)
+		}
+	}
+
+	slices.Sort(hosts)
+
+	for _, host := range hosts {
+		oldDomain, newDomain := n.ReplaceDomain[host], other.ReplaceDomain[host]
+
+		switch {
+		case oldDomain == newDomain:
+		case oldDomain == "":
+			changes = append(changes, fmt.Sprintf("replace-domain: +%s = %s", host, newDomain))
+		case newDomain == "":
+			changes = append(changes, fmt.Sprintf("replace-domain: -%s = %s", host, oldDomain))
+		default:
+			changes = append(changes, fmt.Sprintf("replace-domain: %s = %s -> %s", host, oldDomain, newDomain))
+		}
+	}
+
+	return changes
+}
+
+// ReloadNormalisations loads the configuration again and swaps in its URL normalisation rules,
+// returning what changed. The current rules are kept if the configuration is invalid.
+// Other settings only take effect on restart.
+func (l *Loader) ReloadNormalisations() ([]string, error) {
+	conf, err := l.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := Normalisations().Diff(conf.URLNormalisations)
+	if len(changes) > 0 {
+		SetNormalisations(conf.URLNormalisations)
+	}
+
+	return changes, nil
+}
+
+func (l *Loader) reloadAndLog() {
+	changes, err := l.ReloadNormalisations()
+	if err != nil {
+		slog.Warn("keeping the current URL normalisation rules", "path", l.path, "error", err)
+
+		return
+	}
+
+	if len(changes) == 0 {
+		slog.Info("reloaded URL normalisation rules, which are unchanged", "path", l.path)
+	}
+
+	for _, change := range changes {
+		slog.Info("reloaded URL normalisation rules", "path", l.path, "change", change)
+	}
+}
+
+// WatchNormalisations reloads the URL normalisation rules whenever the config file's modification time
+// changes, checking every interval, or when a signal arrives on reload.
+func (l *Loader) WatchNormalisations(interval time.Duration, reload <-chan os.Signal) {
+	modTime := func() time.Time {
+		if info, err := os.Stat(l.path); err == nil {
+			return info.ModTime()
+		}
+
+		return time.Time{}
+	}
+
+	lastModified := modTime()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if modified := modTime(); !modified.Equal(lastModified) {
+				lastModified = modified
+				l.reloadAndLog()
+			}
+		case <-reload:
+			lastModified = modTime()
+			l.reloadAndLog()
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+var (
+	errInvalidCursor = errors.New("invalid cursor")
+	errCursorSort    = errors.New("cursors only work with links sorted by when they were saved")
+)
+
+// LinkCursor marks a place in a list of links sorted by when they were saved. Unlike a page number, it stays
+// in the same place when links are added or removed before it.
+type LinkCursor struct {
+	SavedAt time.Time
+	ID      uint
+	// Before pages backwards, to the links before the cursor rather than after it.
+	Before bool
+}
+
+// String encodes the cursor for a URL; clients treat it as opaque.
+func (c LinkCursor) String() string {
+	encoded, _ := json.Marshal(c) //nolint:errchkjson
+
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func parseLinkCursor(value string) (*LinkCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var cursor LinkCursor
+	if err := json.Unmarshal(decoded, &cursor); err != nil || cursor.ID == 0 {
+		return nil, errInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+// cursorAt returns a cursor at the link.
+func cursorAt(link Link, before bool) string {
+	return LinkCursor{SavedAt: link.SavedAt, ID: link.ID, Before: before}.String()
+}
+
+// usesCursors reports whether the filter sorts the links in a way cursors can page through.
+func (f LinkFilter) usesCursors() bool {
+	return f.Sort == "saved" || (f.Sort == "" && !f.OnlyRead && !f.OnlyUnread)
+}
+
+func (s *GormStore) GetLinksFrom(userID uint, filter LinkFilter, cursor *LinkCursor, count int) (*[]Link, int64, bool) {
+	query := s.filteredLinks(userID, filter)
+
+	var total int64
+
+	query.Count(&total)
+
+	// paging backwards reads from the cursor in the opposite order, and then turns the links round
+	backwards := cursor != nil && cursor.Before
+	direction, comparison := " desc", "<"
+
+	if filter.Ascending != backwards {
+		direction, comparison = " asc", ">"
+	}
+
+	if cursor != nil {
+		query = query.Where("(saved_at "+comparison+" ? OR (saved_at = ? AND id "+comparison+" ?))",
+			cursor.SavedAt, cursor.SavedAt, cursor.ID)
+	}
+
+	var links []Link
+
+	query.Order("saved_at" + direction).Order("id" + direction).Limit(count + 1).Find(&links)
+
+	more := len(links) > count
+	if more {
+		links = links[:count]
+	}
+
+	if backwards {
+		slices.Reverse(links)
+	}
+
+	return &links, total, more
+}
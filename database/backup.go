@@ -0,0 +1,179 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const backupTimeFormat = "20060102T150405Z"
+
+var (
+	errBackupUnsupported = errors.New("only SQLite databases can be backed up; use pg_dump for PostgreSQL")
+	errBackupExists      = errors.New("backup file already exists")
+	errNotABackup        = errors.New("not a bookmarks database")
+	errBackupDirty       = errors.New("backup was taken while a migration was failing")
+	errBackupTooNew      = errors.New("backup is from a newer version of the schema than this binary supports")
+)
+
+// Backup writes a consistent copy of the SQLite database to path. It is safe to use while the server is running.
+func Backup(db *gorm.DB, path string) error {
+	if CurrentDialect() != SQLite {
+		return errBackupUnsupported
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%w: %s", errBackupExists, path)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { //nolint:gomnd
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	if err := db.Exec("VACUUM INTO ?", path).Error; err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+
+	return nil
+}
+
+// BackupFileName returns the name used for scheduled backups taken at the given time.
+func BackupFileName(now time.Time) string {
+	return fmt.Sprintf("bookmarks-%s.sqlite3", now.UTC().Format(backupTimeFormat))
+}
+
+// RotateBackups deletes all but the newest keep scheduled backups in dir.
+func RotateBackups(dir string, keep int) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "bookmarks-*.sqlite3"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	// the timestamp format sorts chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+
+	if len(paths) <= keep {
+		return nil, nil
+	}
+
+	removed := paths[keep:]
+	for _, path := range removed {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove old backup: %w", err)
+		}
+	}
+
+	return removed, nil
+}
+
+// CheckBackup returns the schema version of a backup, failing if it can't be restored by this binary.
+func CheckBackup(path string) (uint, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, fmt.Errorf("failed to open backup: %w", err)
+	}
+
+	backup, err := gorm.Open(sqlite.Open("file:"+path+"?mode=ro"), &gorm.Config{ //nolint:exhaustruct
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to open backup: %w", err)
+	}
+
+	if sqlDB, err := backup.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	var check string
+	if err := backup.Raw("PRAGMA quick_check").Scan(&check).Error; err != nil || check != "ok" {
+		return 0, fmt.Errorf("%w: %s failed its integrity check", errNotABackup, path)
+	}
+
+	var migration struct {
+		Version uint
+		Dirty   bool
+	}
+
+	if err := backup.Raw("SELECT version, dirty FROM schema_migrations").Scan(&migration).Error; err != nil {
+		return 0, fmt.Errorf("%w: %s has no migration version", errNotABackup, path)
+	}
+
+	if migration.Dirty {
+		return migration.Version, errBackupDirty
+	}
+
+	latest, err := latestMigrationVersion()
+	if err != nil {
+		return migration.Version, err
+	}
+
+	if migration.Version > latest {
+		return migration.Version, fmt.Errorf("%w (backup: %d, latest: %d)", errBackupTooNew, migration.Version, latest)
+	}
+
+	return migration.Version, nil
+}
+
+// Restore replaces the SQLite database with a backup, keeping the old database alongside it with a
+// .pre-restore suffix, then applies any migrations newer than the backup.
+// The server must not be running.
+func Restore(path string) error {
+	if CurrentDialect() != SQLite {
+		return errBackupUnsupported
+	}
+
+	if _, err := CheckBackup(path); err != nil {
+		return err
+	}
+
+	dbPath := sqlitePath(getDatabaseURL())
+	tmpPath := dbPath + ".restoring"
+
+	if err := copyFile(path, tmpPath); err != nil {
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := os.Rename(dbPath, dbPath+".pre-restore"); err != nil {
+			return fmt.Errorf("failed to move current database aside: %w", err)
+		}
+	}
+
+	for _, suffix := range []string{"-wal", "-shm"} {
+		_ = os.Remove(dbPath + suffix)
+	}
+
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		return fmt.Errorf("failed to replace database: %w", err)
+	}
+
+	return RunMigrations()
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+
+		return err //nolint:wrapcheck
+	}
+
+	return out.Close() //nolint:wrapcheck
+}
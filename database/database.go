@@ -3,46 +3,230 @@ package database
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/benjamineskola/bookmarks/config"
+	"github.com/benjamineskola/bookmarks/migrations"
 	migrate "github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/pgx/v5"  // doesn't need to be referenced
 	_ "github.com/golang-migrate/migrate/v4/database/sqlite3" // doesn't need to be referenced
-	_ "github.com/golang-migrate/migrate/v4/source/file"      // doesn't need to be referenced
-	_ "github.com/mattn/go-sqlite3"                           // doesn't need to be referenced
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/mattn/go-sqlite3" // doesn't need to be referenced
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
-var DB *gorm.DB //nolint:gochecknoglobals
+type Dialect string
 
-func getDBPath() string {
-	env := os.Getenv("ENVIRONMENT")
-	if env == "" {
-		env = "development"
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// getDatabaseURL returns the configured database location, defaulting to the SQLite database
+// data/{environment}.sqlite3.
+func getDatabaseURL() string {
+	if dbURL := config.Config.Database.URL; dbURL != "" {
+		return dbURL
 	}
 
-	return fmt.Sprintf("data/%s.sqlite3", env)
+	return fmt.Sprintf("data/%s.sqlite3", config.Config.Environment)
 }
 
-func InitDatabase() *gorm.DB {
-	db, _ := gorm.Open(sqlite.Open(getDBPath()),
-		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)}) //nolint:exhaustruct
+func dialectForURL(dbURL string) Dialect {
+	if strings.HasPrefix(dbURL, "postgres://") || strings.HasPrefix(dbURL, "postgresql://") {
+		return Postgres
+	}
+
+	return SQLite
+}
 
-	return db
+// CurrentDialect reports which kind of database is configured.
+func CurrentDialect() Dialect {
+	return dialectForURL(getDatabaseURL())
 }
 
-func RunMigrations() error {
-	migrateDir := "file://migrations/"
+func sqlitePath(dbURL string) string {
+	for _, prefix := range []string{"sqlite3://", "sqlite://", "file:"} {
+		dbURL = strings.TrimPrefix(dbURL, prefix)
+	}
+
+	return dbURL
+}
+
+func InitDatabase() (*gorm.DB, error) {
+	dbURL := getDatabaseURL()
+	gormConfig := &gorm.Config{Logger: NewSlowQueryLogger(config.Config.Log.SlowQuery)} //nolint:exhaustruct
+
+	var dialector gorm.Dialector
 
-	m, err := migrate.New(migrateDir, "sqlite3://"+getDBPath())
+	switch dialectForURL(dbURL) {
+	case Postgres:
+		dialector = postgres.Open(dbURL)
+	case SQLite:
+		path := sqlitePath(dbURL)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil { //nolint:gomnd
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+
+		dialector = sqlite.Open(path)
+	}
+
+	db, err := gorm.Open(dialector, gormConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	return db, nil
+}
+
+func newMigrationSource() (source.Driver, error) {
+	src, err := iofs.New(migrations.FS, string(CurrentDialect()))
 	if err != nil {
-		return fmt.Errorf("failed to initialise migrations: %w", err)
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
 	}
 
+	return src, nil
+}
+
+func newMigrate() (*migrate.Migrate, error) {
+	src, err := newMigrationSource()
+	if err != nil {
+		return nil, err
+	}
+
+	var migrateURL string
+
+	switch dbURL := getDatabaseURL(); dialectForURL(dbURL) {
+	case Postgres:
+		_, rest, _ := strings.Cut(dbURL, "://")
+		migrateURL = "pgx5://" + rest
+	case SQLite:
+		path := sqlitePath(dbURL)
+		_ = os.MkdirAll(filepath.Dir(path), 0o755) //nolint:gomnd
+
+		migrateURL = "sqlite3://" + path
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", src, migrateURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise migrations: %w", err)
+	}
+
+	return m, nil
+}
+
+func RunMigrations() error {
+	m, err := newMigrate()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
 	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
 		return fmt.Errorf("failed to apply migrations: %w", err)
 	}
 
 	return nil
 }
+
+// MigrateDown rolls back the given number of migrations.
+func MigrateDown(steps int) error {
+	m, err := newMigrate()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Steps(-steps); err != nil {
+		return fmt.Errorf("failed to roll back migrations: %w", err)
+	}
+
+	return nil
+}
+
+// ForceMigration sets the recorded version without running anything, to recover from a failed migration.
+func ForceMigration(version int) error {
+	m, err := newMigrate()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.Force(version); err != nil {
+		return fmt.Errorf("failed to force migration version: %w", err)
+	}
+
+	return nil
+}
+
+type MigrationStatus struct {
+	Version uint
+	Dirty   bool
+	Latest  uint
+	Pending int
+}
+
+func GetMigrationStatus() (MigrationStatus, error) {
+	var status MigrationStatus
+
+	m, err := newMigrate()
+	if err != nil {
+		return status, err
+	}
+	defer m.Close()
+
+	status.Version, status.Dirty, err = m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return status, fmt.Errorf("failed to read migration version: %w", err)
+	}
+
+	src, err := newMigrationSource()
+	if err != nil {
+		return status, err
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	for err == nil {
+		if version > status.Version {
+			status.Pending++
+		}
+
+		status.Latest = version
+		version, err = src.Next(version)
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return status, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	return status, nil
+}
+
+func latestMigrationVersion() (uint, error) {
+	src, err := newMigrationSource()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	var latest uint
+
+	version, err := src.First()
+	for err == nil {
+		latest = version
+		version, err = src.Next(version)
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	return latest, nil
+}
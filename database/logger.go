@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// slowQueryLogger sends GORM's logging to slog, reporting queries slower than the threshold.
+type slowQueryLogger struct {
+	threshold time.Duration
+	level     logger.LogLevel
+}
+
+// requestLogger is implemented by the log the server keeps in each request's context under "logger".
+type requestLogger interface {
+	Logger() *slog.Logger
+}
+
+// loggerFor returns the logger of the request the query is for, so that its log lines identify the request
+// and the user, or the default logger for queries outside of a request.
+func loggerFor(ctx context.Context) *slog.Logger {
+	if entry, ok := ctx.Value("logger").(requestLogger); ok {
+		return entry.Logger()
+	}
+
+	return slog.Default()
+}
+
+// NewSlowQueryLogger returns a GORM logger which logs queries slower than the threshold as warnings.
+func NewSlowQueryLogger(threshold time.Duration) logger.Interface {
+	return &slowQueryLogger{threshold: threshold, level: logger.Warn}
+}
+
+func (l *slowQueryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	newLogger := *l
+	newLogger.level = level
+
+	return &newLogger
+}
+
+func (l *slowQueryLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Info {
+		loggerFor(ctx).InfoContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *slowQueryLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Warn {
+		loggerFor(ctx).WarnContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *slowQueryLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Error {
+		loggerFor(ctx).ErrorContext(ctx, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *slowQueryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+
+	switch {
+	case l.threshold > 0 && elapsed > l.threshold && l.level >= logger.Warn:
+		sql, rows := fc()
+		loggerFor(ctx).WarnContext(ctx, "slow query", "sql", sql, "rows", rows, "duration", elapsed)
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		// the caller decides whether the error matters, so only log it for debugging
+		sql, _ := fc()
+		loggerFor(ctx).DebugContext(ctx, "query failed", "sql", sql, "error", err, "duration", elapsed)
+	}
+}
@@ -0,0 +1,1048 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benjamineskola/bookmarks/config"
+	"github.com/benjamineskola/bookmarks/database"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var csrfTokenPattern = regexp.MustCompile(`name="gorilla.csrf.Token" value="([^"]+)"`)
+
+type testClient struct {
+	t      *testing.T
+	server *httptest.Server
+	client *http.Client
+	// clock drives the login rate limiter
+	clock *fakeClock
+	logs  *lockedBuffer
+}
+
+// lockedBuffer collects the server's logs, which are written from other goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p) //nolint:wrapcheck
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// newTestApp starts a server backed by an in-memory store containing one user, alice@example.com. The options
+// can change the app before it starts.
+func newTestApp(t *testing.T, options ...func(*App)) (*testClient, *MemoryStore, *User) {
+	t.Helper()
+
+	store := NewMemoryStore()
+
+	user, err := NewUser("alice", "alice@example.com", "password")
+	assert.Nil(t, err)
+	assert.Nil(t, store.CreateUser(user))
+
+	clock := &fakeClock{now: time.Now()} //nolint:exhaustruct
+	secretKey := []byte("0123456789abcdef0123456789abcdef")
+	logs := &lockedBuffer{} //nolint:exhaustruct
+	app := &App{
+		Links:        store,
+		Users:        store,
+		Sessions:     NewSessionManager(store, secretKey, time.Hour, time.Hour, false),
+		LoginLimiter: NewRateLimiter(NewMemoryRateLimitStore(), clock),
+		CSRFKey:      secretKey,
+		Secure:       false,
+
+		PageSize:       config.DefaultPageSize,
+		PublicProfiles: true,
+
+		Metrics:    NewMetrics(store, store, config.Metrics{Allow: nil, Token: "metrics-token"}),
+		CheckReady: nil,
+		Logger:     slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})), //nolint:exhaustruct
+	}
+
+	for _, option := range options {
+		option(app)
+	}
+
+	server := httptest.NewServer(app.Router())
+	t.Cleanup(server.Close)
+
+	jar, err := cookiejar.New(nil)
+	assert.Nil(t, err)
+
+	client := &http.Client{ //nolint:exhaustruct
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return &testClient{t: t, server: server, client: client, clock: clock, logs: logs}, store, user
+}
+
+func (c *testClient) do(method string, path string, form url.Values) (*http.Response, string) {
+	c.t.Helper()
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, c.server.URL+path, body) //nolint:noctx
+	assert.Nil(c.t, err)
+
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	return c.send(req)
+}
+
+// doJSON makes a request with a JSON body, as scripts using the session do.
+func (c *testClient) doJSON(method string, path string, body any) (*http.Response, string) {
+	c.t.Helper()
+
+	encoded, err := json.Marshal(body)
+	assert.Nil(c.t, err)
+
+	req, err := http.NewRequest(method, c.server.URL+path, bytes.NewReader(encoded)) //nolint:noctx
+	assert.Nil(c.t, err)
+
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.send(req)
+}
+
+func (c *testClient) send(req *http.Request) (*http.Response, string) {
+	c.t.Helper()
+
+	if req.Method != http.MethodGet {
+		req.Header.Set("X-CSRF-Token", c.csrfToken())
+	}
+
+	resp, err := c.client.Do(req)
+	assert.Nil(c.t, err)
+
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	assert.Nil(c.t, err)
+
+	return resp, string(content)
+}
+
+// csrfToken fetches the login form to get a token matching the client's CSRF cookie.
+func (c *testClient) csrfToken() string {
+	c.t.Helper()
+
+	_, body := c.do(http.MethodGet, "/auth/login/", nil)
+
+	match := csrfTokenPattern.FindStringSubmatch(body)
+	assert.Len(c.t, match, 2)
+
+	return match[1]
+}
+
+func (c *testClient) login(email string, password string) *http.Response {
+	c.t.Helper()
+
+	resp, _ := c.do(http.MethodPost, "/auth/login/", url.Values{"email": {email}, "password": {password}})
+
+	return resp
+}
+
+// newClient returns a client for the same server with its own cookies, like a second browser.
+func (c *testClient) newClient() *testClient {
+	c.t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	assert.Nil(c.t, err)
+
+	client := &http.Client{ //nolint:exhaustruct
+		Jar:           jar,
+		CheckRedirect: c.client.CheckRedirect,
+	}
+
+	return &testClient{t: c.t, server: c.server, client: client, clock: c.clock, logs: c.logs}
+}
+
+func TestLoginHandler(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+
+	resp := client.login("alice@example.com", "wrong")
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/auth/login/", resp.Header.Get("Location"))
+	assert.Len(t, store.failedLogins, 1)
+	assert.Empty(t, store.GetSessionsForUser(user.ID))
+
+	resp = client.login("alice@example.com", "password")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	client.clock.Advance(time.Second)
+
+	resp = client.login("Alice@Example.com", "password")
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/links/", resp.Header.Get("Location"))
+	assert.Len(t, store.GetSessionsForUser(user.ID), 1)
+
+	resp, _ = client.do(http.MethodGet, "/auth/sessions/", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = client.do(http.MethodPost, "/auth/logout/", url.Values{})
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Empty(t, store.GetSessionsForUser(user.ID))
+
+	resp, _ = client.do(http.MethodGet, "/auth/sessions/", nil)
+	assert.Equal(t, "/auth/login/", resp.Header.Get("Location"))
+
+	// the address's earlier failure isn't forgotten when alice logs in, so the wait for it grows
+	resp = client.login("bob@example.com", "wrong")
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	client.clock.Advance(time.Second)
+
+	resp = client.login("carol@example.com", "wrong")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestSessionsExpireAndRevoke(t *testing.T) {
+	t.Parallel()
+
+	user, err := NewUser("", "sessions@example.com", "password")
+	assert.Nil(t, err)
+	assert.Nil(t, testStore.CreateUser(user))
+
+	laptop, _, _ := newTestApp(t, func(app *App) {
+		app.Users = testStore
+		app.Sessions = NewSessionManager(testStore, app.CSRFKey, time.Hour, 24*time.Hour, false)
+	})
+	phone := laptop.newClient()
+
+	loggedIn := func(client *testClient) bool {
+		resp, _ := client.do(http.MethodGet, "/auth/sessions/", nil)
+
+		return resp.StatusCode == http.StatusOK
+	}
+	age := func(column string, by time.Duration) {
+		sessions := testStore.db.Model(&Session{}).Where("user_id = ?", user.ID) //nolint:exhaustruct
+		result := sessions.Update(column, time.Now().Add(-by))
+		assert.Nil(t, result.Error)
+	}
+
+	assert.Equal(t, http.StatusSeeOther, laptop.login("sessions@example.com", "password").StatusCode)
+	assert.True(t, loggedIn(laptop))
+
+	age("last_seen_at", 2*time.Hour)
+	assert.False(t, loggedIn(laptop))
+	assert.Empty(t, testStore.GetSessionsForUser(user.ID))
+
+	laptop.login("sessions@example.com", "password")
+	age("created_at", 25*time.Hour)
+	assert.False(t, loggedIn(laptop))
+	assert.Empty(t, testStore.GetSessionsForUser(user.ID))
+
+	laptop.login("sessions@example.com", "password")
+	laptopSession := testStore.GetSessionsForUser(user.ID)[0]
+	phone.login("sessions@example.com", "password")
+
+	sessions := testStore.GetSessionsForUser(user.ID)
+	assert.Len(t, sessions, 2)
+
+	for _, session := range sessions {
+		if session.ID != laptopSession.ID {
+			resp, _ := laptop.do(http.MethodPost, "/auth/sessions/"+itoa(session.ID)+"/revoke", url.Values{})
+			assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+		}
+	}
+
+	assert.False(t, loggedIn(phone))
+	assert.True(t, loggedIn(laptop))
+
+	phone.login("sessions@example.com", "password")
+	assert.True(t, loggedIn(phone))
+
+	resp, _ := phone.do(http.MethodPost, "/auth/sessions/revoke-all", url.Values{})
+	assert.Equal(t, "/auth/login/", resp.Header.Get("Location"))
+	assert.False(t, loggedIn(phone))
+	assert.False(t, loggedIn(laptop))
+	assert.Empty(t, testStore.GetSessionsForUser(user.ID))
+}
+
+func TestLoginTOTPRateLimit(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t, func(app *App) {
+		app.TrustedProxies = config.Server{TrustedProxies: []string{"127.0.0.1"}}.TrustedNetworks() //nolint:exhaustruct
+	})
+
+	assert.Nil(t, user.BeginTOTPEnrolment(store))
+	code, err := totpCode(user.TOTPSecret, totpStep(time.Now()))
+	assert.Nil(t, err)
+	_, err = user.EnableTOTP(store, code)
+	assert.Nil(t, err)
+
+	// each attempt comes from a different address, so only the account's failures count, and getting the
+	// password right again doesn't clear the failed codes
+	post := func(path string, form url.Values, attempt int) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, client.server.URL+path, strings.NewReader(form.Encode())) //nolint:noctx
+		assert.Nil(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Forwarded-For", "192.0.2."+strconv.Itoa(attempt))
+
+		resp, _ := client.send(req)
+
+		return resp
+	}
+
+	for i := 0; i < defaultMaxFailures; i++ {
+		resp := post("/auth/login/", url.Values{"email": {"alice@example.com"}, "password": {"password"}}, i)
+		assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+		assert.Equal(t, "/auth/login/2fa", resp.Header.Get("Location"))
+
+		resp = post("/auth/login/2fa", url.Values{"code": {"wrong"}}, i)
+		assert.Equal(t, "/auth/login/2fa", resp.Header.Get("Location"))
+
+		client.clock.Advance(time.Minute)
+	}
+
+	resp := post("/auth/login/", url.Values{"email": {"alice@example.com"}, "password": {"password"}}, defaultMaxFailures)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Empty(t, store.GetSessionsForUser(user.ID))
+}
+
+func TestTwoFactorSettingsRateLimit(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+	client.login("alice@example.com", "password")
+
+	assert.Nil(t, user.BeginTOTPEnrolment(store))
+	code, err := totpCode(user.TOTPSecret, totpStep(time.Now()))
+	assert.Nil(t, err)
+	_, err = user.EnableTOTP(store, code)
+	assert.Nil(t, err)
+
+	// wrong codes for either form count towards the same lockout
+	for i := 0; i < defaultMaxFailures; i++ {
+		path := []string{"/auth/2fa/disable", "/auth/2fa/recovery-codes"}[i%2]
+		resp, _ := client.do(http.MethodPost, path, url.Values{"code": {"wrong"}})
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		client.clock.Advance(time.Minute)
+	}
+
+	code, err = totpCode(user.TOTPSecret, totpStep(time.Now())+1)
+	assert.Nil(t, err)
+
+	for _, path := range []string{"/auth/2fa/disable", "/auth/2fa/recovery-codes"} {
+		resp, _ := client.do(http.MethodPost, path, url.Values{"code": {code}})
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	}
+
+	assert.True(t, store.GetUserByID(user.ID).TOTPEnabled)
+}
+
+func TestPasswordHandlerRateLimit(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+	client.login("alice@example.com", "password")
+
+	change := func(current string) *http.Response {
+		resp, _ := client.do(http.MethodPost, "/auth/password/", url.Values{
+			"current_password": {current}, "new_password": {"new password"}, "confirm_password": {"new password"},
+		})
+
+		return resp
+	}
+
+	resp := change("wrong")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = change("password")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	assert.Nil(t, store.GetUserByID(user.ID).CheckPassword("password"))
+
+	client.clock.Advance(time.Second)
+
+	resp = change("password")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Nil(t, store.GetUserByID(user.ID).CheckPassword("new password"))
+}
+
+func TestIndexHandler(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+
+	for _, link := range []*Link{
+		NewLink(user.ID, "https://public.example.com/", "A public link", "", true),
+		NewLink(user.ID, "https://private.example.com/", "A private link", "", false),
+	} {
+		link.SavedAt = time.Now()
+		_, err := store.SaveLink(link, RevisionSourceCLI)
+		assert.Nil(t, err)
+	}
+
+	resp, body := client.do(http.MethodGet, "/links/", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "A public link")
+	assert.NotContains(t, body, "A private link")
+
+	client.login("alice@example.com", "password")
+
+	_, body = client.do(http.MethodGet, "/links/", nil)
+	assert.Contains(t, body, "A public link")
+	assert.Contains(t, body, "A private link")
+
+	_, body = client.do(http.MethodGet, "/u/alice/links/public.json", nil)
+	assert.Contains(t, body, "A public link")
+	assert.NotContains(t, body, "A private link")
+
+	_, body = client.do(http.MethodGet, "/links/page/2.json?sort=title&order=asc&per_page=1", nil)
+
+	var page linkPage
+	assert.Nil(t, json.Unmarshal([]byte(body), &page))
+	assert.Equal(t, int64(2), page.Total)
+	assert.Equal(t, 2, page.Page)
+	assert.Equal(t, 1, page.PerPage)
+	assert.Equal(t, "A public link", (*page.Links)[0].Title)
+
+	_, body = client.do(http.MethodGet, "/links/?sort=title&per_page=1", nil)
+	assert.Contains(t, body, `href="/links/page/2?sort=title&amp;per_page=1"`)
+	assert.Contains(t, body, "A private link")
+	assert.NotContains(t, body, "A public link")
+
+	_, body = client.do(http.MethodGet, "/links.json?per_page=100000", nil)
+	assert.Contains(t, body, `"per_page":`+strconv.Itoa(config.MaxPageSize))
+
+	resp, _ = client.do(http.MethodGet, "/links.json?sort=colour", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestIndexCursors(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 1; i <= 3; i++ {
+		link := NewLink(user.ID, "https://example.com/"+strconv.Itoa(i), "Link "+strconv.Itoa(i), "", false)
+		link.SavedAt = start.Add(time.Duration(i) * time.Hour)
+		_, err := store.SaveLink(link, RevisionSourceCLI)
+		assert.Nil(t, err)
+	}
+
+	client.login("alice@example.com", "password")
+
+	get := func(path string) (*http.Response, linkPage) {
+		resp, body := client.do(http.MethodGet, path, nil)
+
+		var page linkPage
+		assert.Nil(t, json.Unmarshal([]byte(body), &page))
+
+		return resp, page
+	}
+
+	resp, page := get("/links.json?per_page=2")
+	assert.Equal(t, "Link 3", (*page.Links)[0].Title)
+	assert.Empty(t, page.Prev)
+	assert.NotEmpty(t, page.Next)
+	assert.Equal(t, `</links.json?cursor=`+page.Next+`&per_page=2>; rel="next"`, resp.Header.Get("Link"))
+
+	resp, page = get("/links.json?per_page=2&cursor=" + page.Next)
+	assert.Equal(t, "Link 1", (*page.Links)[0].Title)
+	assert.Len(t, *page.Links, 1)
+	assert.Zero(t, page.Page)
+	assert.Empty(t, page.Next)
+	assert.Contains(t, resp.Header.Get("Link"), `rel="prev"`)
+
+	_, page = get("/links.json?per_page=2&cursor=" + page.Prev)
+	assert.Equal(t, "Link 3", (*page.Links)[0].Title)
+	assert.Empty(t, page.Prev)
+
+	resp, _ = client.do(http.MethodGet, "/links.json?cursor=nonsense", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = client.do(http.MethodGet, "/links.json?sort=title&cursor="+page.Next, nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestIndexConditionalRequests(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+
+	link := NewLink(user.ID, "https://example.com/cached", "Cached", "", true)
+	_, err := store.SaveLink(link, RevisionSourceCLI)
+	assert.Nil(t, err)
+
+	get := func(path string, header string, value string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, client.server.URL+path, nil) //nolint:noctx
+		assert.Nil(t, err)
+
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+
+		return client.send(req)
+	}
+
+	resp, _ := get("/u/alice/links/public.json", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "public, no-cache", resp.Header.Get("Cache-Control"))
+
+	etag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	resp, body := get("/u/alice/links/public.json", "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Empty(t, body)
+
+	resp, _ = get("/u/alice/links/public.json", "If-Modified-Since", resp.Header.Get("Last-Modified"))
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// other pages of the same links have their own tags
+	resp, _ = get("/u/alice/links/public.json?per_page=1", "If-None-Match", etag)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	link.Title = "Changed"
+	_, err = store.SaveLink(link, RevisionSourceCLI)
+	assert.Nil(t, err)
+
+	resp, _ = get("/u/alice/links/public.json", "If-None-Match", etag)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	etag = resp.Header.Get("ETag")
+
+	assert.Nil(t, store.DeleteLink(user.ID, link.ID))
+
+	resp, _ = get("/u/alice/links/public.json", "If-None-Match", etag)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	client.login("alice@example.com", "password")
+
+	resp, _ = get("/links/", "", "")
+	assert.Equal(t, "private, no-cache", resp.Header.Get("Cache-Control"))
+}
+
+func TestIndexIfModifiedSince(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+
+	var links []*Link
+
+	for _, path := range []string{"kept", "hidden", "deleted"} {
+		link := NewLink(user.ID, "https://example.com/"+path, "", "", true)
+		_, err := store.SaveLink(link, RevisionSourceCLI)
+		assert.Nil(t, err)
+
+		links = append(links, link)
+	}
+
+	// Last-Modified is only precise to the second, so move the links' history back to before now
+	backdate := func() {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+
+		for id, link := range store.links {
+			link.UpdatedAt = link.UpdatedAt.Add(-time.Hour)
+			store.links[id] = link
+		}
+	}
+
+	lastModified := func() string {
+		backdate()
+
+		resp, _ := client.do(http.MethodGet, "/u/alice/links/public.json", nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		return resp.Header.Get("Last-Modified")
+	}
+
+	ifModifiedSince := func(since string) int {
+		req, err := http.NewRequest(http.MethodGet, client.server.URL+"/u/alice/links/public.json", nil) //nolint:noctx
+		assert.Nil(t, err)
+		req.Header.Set("If-Modified-Since", since)
+
+		resp, _ := client.send(req)
+
+		return resp.StatusCode
+	}
+
+	since := lastModified()
+	assert.Equal(t, http.StatusNotModified, ifModifiedSince(since))
+
+	// a link leaving the public links changes them
+	links[1].Public = false
+	_, err := store.SaveLink(links[1], RevisionSourceCLI)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, ifModifiedSince(since))
+
+	// as does deleting one, which only sets when it was deleted
+	since = lastModified()
+	assert.Nil(t, store.DeleteLink(user.ID, links[2].ID))
+	assert.Equal(t, http.StatusOK, ifModifiedSince(since))
+}
+
+func TestUnreadQueueHandler(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+
+	for i, title := range []string{"First unread", "Second unread", "Already read"} {
+		link := NewLink(user.ID, "https://queue.example.com/"+itoa(uint(i)), title, "", false)
+		link.SavedAt = time.Now().Add(time.Duration(i) * time.Minute)
+		link.WordCount = 2300
+
+		if title == "Already read" {
+			link.ReadAt = time.Now()
+		}
+
+		_, err := store.SaveLink(link, RevisionSourceCLI)
+		assert.Nil(t, err)
+	}
+
+	client.login("alice@example.com", "password")
+
+	_, body := client.do(http.MethodGet, "/links/unread/", nil)
+	assert.Contains(t, body, "2 unread")
+	assert.Contains(t, body, "about 20 min to read")
+	assert.NotContains(t, body, "Already read")
+	assert.Less(t, strings.Index(body, "First unread"), strings.Index(body, "Second unread"))
+
+	_, body = client.do(http.MethodGet, "/links/unread/?order=desc", nil)
+	assert.Less(t, strings.Index(body, "Second unread"), strings.Index(body, "First unread"))
+
+	second, err := store.GetLinkByURL(user.ID, "https://queue.example.com/1")
+	assert.Nil(t, err)
+
+	form := url.Values{"Link.URL": {second.URL.String()}, "Link.Title": {"Second unread"}, "Link.Priority": {"2"}}
+	resp, _ := client.do(http.MethodPost, "/links/"+itoa(second.ID), form)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	_, body = client.do(http.MethodGet, "/links/unread/", nil)
+	assert.Less(t, strings.Index(body, "Second unread"), strings.Index(body, "First unread"))
+	assert.Contains(t, body, "urgent")
+
+	form.Set("Link.Priority", "9")
+	resp, _ = client.do(http.MethodPost, "/links/"+itoa(second.ID), form)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, _ = client.do(http.MethodGet, "/links/unread/random", nil)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Regexp(t, `^/links/\d+/$`, resp.Header.Get("Location"))
+	read, err := store.GetLinkByURL(user.ID, "https://queue.example.com/2")
+	assert.Nil(t, err)
+	assert.NotEqual(t, "/links/"+itoa(read.ID)+"/", resp.Header.Get("Location"))
+}
+
+func TestSaveHandler(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+	form := url.Values{"Link.URL": {"https://example.com/saved"}, "Link.Title": {"Saved"}}
+
+	resp, _ := client.do(http.MethodPost, "/links/", form)
+	assert.Equal(t, "/auth/login/", resp.Header.Get("Location"))
+	assert.Empty(t, store.links)
+
+	client.login("alice@example.com", "password")
+
+	resp, _ = client.do(http.MethodPost, "/links/", form)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	link, err := store.GetLinkByURL(user.ID, "https://example.com/saved")
+	assert.Nil(t, err)
+	assert.Equal(t, "Saved", link.Title)
+
+	form.Set("Link.Title", "Renamed")
+	resp, _ = client.do(http.MethodPost, "/links/"+itoa(link.ID), form)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+	revisions := store.GetLinkRevisions(link.ID)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, RevisionSourceWeb, revisions[0].Source)
+	assert.Equal(t, FieldChange{Old: "Saved", New: "Renamed"}, revisions[0].Changes["Title"])
+
+	resp, _ = client.do(http.MethodPost, "/links/999", form)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = client.do(http.MethodPost, "/links/", url.Values{"Link.URL": {"http://[::1"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body := client.do(http.MethodPost, "/links/", url.Values{"Link.Title": {"No URL"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body, errMissingURL.Error())
+
+	// the error quotes the URL, which must be escaped
+	resp, body = client.doJSON(http.MethodPost, "/links/.json", map[string]any{"URL": "http://[::1"})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	var jsonErr jsonError
+	assert.Nil(t, json.Unmarshal([]byte(body), &jsonErr))
+	assert.Equal(t, http.StatusBadRequest, jsonErr.Status)
+	assert.Contains(t, jsonErr.Message, `"http://[::1"`)
+
+	// a charset doesn't stop the body being read as JSON
+	req, err := http.NewRequest(http.MethodPost, client.server.URL+"/links/.json", //nolint:noctx
+		strings.NewReader(`{"URL": "https://example.com/charset"}`))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	resp, _ = client.send(req)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = store.GetLinkByURL(user.ID, "https://example.com/charset")
+	assert.Nil(t, err)
+
+	// changing the URL to one in the trash is refused, as saving it as a new link is
+	trashedID, err := store.SaveLink(NewLink(user.ID, "https://example.com/trashed", "", "", false), RevisionSourceCLI)
+	assert.Nil(t, err)
+	assert.Nil(t, store.DeleteLink(user.ID, trashedID))
+
+	form.Set("Link.URL", "https://example.com/trashed")
+	resp, body = client.do(http.MethodPost, "/links/"+itoa(link.ID), form)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Contains(t, body, "/links/trash/"+itoa(trashedID)+"/restore")
+
+	resp, _ = client.doJSON(http.MethodPost, "/links/"+itoa(link.ID)+".json", map[string]any{
+		"URL": "https://example.com/trashed",
+	})
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	link, err = store.GetLinkByID(user.ID, link.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://example.com/saved", link.URL.String())
+}
+
+func TestRevertToTrashedURL(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+
+	id, err := store.SaveLink(NewLink(user.ID, "https://example.com/old", "Moved", "", false), RevisionSourceCLI)
+	assert.Nil(t, err)
+
+	link, err := store.GetLinkByID(user.ID, id)
+	assert.Nil(t, err)
+	link.URL, err = parseURL("https://example.com/new")
+	assert.Nil(t, err)
+	_, err = store.SaveLink(link, RevisionSourceCLI)
+	assert.Nil(t, err)
+
+	trashedID, err := store.SaveLink(NewLink(user.ID, "https://example.com/old", "Old", "", false), RevisionSourceCLI)
+	assert.Nil(t, err)
+	assert.Nil(t, store.DeleteLink(user.ID, trashedID))
+
+	client.login("alice@example.com", "password")
+
+	revisions := store.GetLinkRevisions(id)
+	assert.Len(t, revisions, 2)
+
+	resp, body := client.do(http.MethodPost, "/links/"+itoa(id)+"/revisions/"+itoa(revisions[1].ID)+"/revert",
+		url.Values{})
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Contains(t, body, "/links/trash/"+itoa(trashedID)+"/restore")
+
+	link, err = store.GetLinkByID(user.ID, id)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://example.com/new", link.URL.String())
+	assert.Len(t, store.GetLinkRevisions(id), 2)
+}
+
+func TestDeleteHandler(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+
+	id, err := store.SaveLink(NewLink(user.ID, "https://example.com/deleted", "Deleted", "", false), RevisionSourceCLI)
+	assert.Nil(t, err)
+
+	client.login("alice@example.com", "password")
+
+	resp, body := client.do(http.MethodDelete, "/links/"+itoa(id), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"result": "success"}`, body)
+	assert.Equal(t, id, store.GetTrashedLinkByURL(user.ID, "https://example.com/deleted").ID)
+
+	resp, _ = client.do(http.MethodDelete, "/links/"+itoa(id), nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestLinkActionHandlers(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+
+	id, err := store.SaveLink(NewLink(user.ID, "https://example.com/action", "Action", "", false), RevisionSourceCLI)
+	assert.Nil(t, err)
+
+	client.login("alice@example.com", "password")
+
+	_, body := client.do(http.MethodGet, "/links/unread/?order=desc", nil)
+	assert.Contains(t, body, `action="/links/`+itoa(id)+`/read"`)
+	assert.Contains(t, body, `name="next" value="/links/unread/?order=desc"`)
+
+	resp, _ := client.do(http.MethodPost, "/links/"+itoa(id)+"/read", url.Values{"next": {"/links/unread/?order=desc"}})
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/links/unread/?order=desc", resp.Header.Get("Location"))
+
+	link, err := store.GetLinkByID(user.ID, id)
+	assert.Nil(t, err)
+	assert.True(t, link.IsRead())
+
+	resp, body = client.do(http.MethodPost, "/links/"+itoa(id)+"/public.json", url.Values{})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result Link
+	assert.Nil(t, json.Unmarshal([]byte(body), &result))
+	assert.True(t, result.Public)
+	assert.True(t, result.IsRead())
+
+	resp, _ = client.do(http.MethodPost, "/links/"+itoa(id)+"/unread", url.Values{"next": {"//example.com/"}})
+	assert.Equal(t, "/links/", resp.Header.Get("Location"))
+
+	link, err = store.GetLinkByID(user.ID, id)
+	assert.Nil(t, err)
+	assert.False(t, link.IsRead())
+	assert.True(t, link.Public)
+
+	resp, _ = client.do(http.MethodPost, "/links/"+itoa(id)+"/delete", url.Values{"next": {"/links/page/2"}})
+	assert.Equal(t, "/links/page/2", resp.Header.Get("Location"))
+	assert.Equal(t, id, store.GetTrashedLinkByURL(user.ID, "https://example.com/action").ID)
+
+	resp, _ = client.do(http.MethodPost, "/links/"+itoa(id)+"/read.json", url.Values{})
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestBulkHandler(t *testing.T) {
+	t.Parallel()
+
+	client, store, user := newTestApp(t)
+
+	var ids []uint
+
+	for _, path := range []string{"a", "b", "c"} {
+		id, err := store.SaveLink(NewLink(user.ID, "https://example.com/"+path, "", "", false), RevisionSourceCLI)
+		assert.Nil(t, err)
+
+		ids = append(ids, id)
+	}
+
+	client.login("alice@example.com", "password")
+
+	_, body := client.do(http.MethodGet, "/links/?q=example", nil)
+	assert.Contains(t, body, `<form id="bulk" action="/links/bulk"`)
+	assert.Contains(t, body, `name="ids" value="`+itoa(ids[0])+`" form="bulk"`)
+
+	form := url.Values{
+		"ids": {itoa(ids[0]), itoa(ids[1])}, "action": {"add-tags"}, "tags": {"x,y"}, "next": {"/links/?q=example"},
+	}
+	resp, _ := client.do(http.MethodPost, "/links/bulk", form)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/links/?q=example", resp.Header.Get("Location"))
+
+	link, err := store.GetLinkByID(user.ID, ids[1])
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"x", "y"}, link.Tags.Sorted())
+
+	resp, _ = client.do(http.MethodPost, "/links/bulk", url.Values{"ids": {itoa(ids[0])}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// adding or removing blank tags is no change at all
+	for _, action := range []string{"add-tags", "remove-tags"} {
+		resp, body = client.do(http.MethodPost, "/links/bulk",
+			url.Values{"ids": {itoa(ids[0])}, "action": {action}, "tags": {" "}})
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Contains(t, body, errNoBulkChange.Error())
+	}
+
+	resp, _ = client.doJSON(http.MethodPost, "/links/bulk.json",
+		map[string]any{"IDs": []uint{ids[0]}, "AddTags": []string{""}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body = client.doJSON(http.MethodPost, "/links/bulk.json",
+		map[string]any{"IDs": []uint{ids[1], ids[2]}, "Public": true, "Read": true})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"result": "success", "links": 2}`, body)
+
+	link, err = store.GetLinkByID(user.ID, ids[2])
+	assert.Nil(t, err)
+	assert.True(t, link.Public)
+	assert.True(t, link.IsRead())
+	assert.Equal(t, RevisionSourceAPI, store.GetLinkRevisions(ids[2])[0].Source)
+
+	resp, _ = client.doJSON(http.MethodPost, "/links/bulk.json",
+		map[string]any{"IDs": []uint{ids[0], 999}, "Delete": true})
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	_, err = store.GetLinkByID(user.ID, ids[0])
+	assert.Nil(t, err)
+}
+
+func TestHealthAndMetrics(t *testing.T) {
+	t.Parallel()
+
+	client, _, _ := newTestApp(t)
+
+	resp, _ := client.do(http.MethodGet, "/healthz", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = client.do(http.MethodGet, "/readyz", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = client.do(http.MethodGet, "/metrics", nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "only allowed clients can see the metrics")
+
+	req, err := http.NewRequest(http.MethodGet, client.server.URL+"/metrics", nil) //nolint:noctx
+	assert.Nil(t, err)
+	req.Header.Set("Authorization", "Bearer metrics-token")
+
+	resp, err = client.client.Do(req)
+	assert.Nil(t, err)
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `bookmarks_http_requests_total{method="GET",route="/healthz",status="200"} 1`)
+	assert.Contains(t, string(body), "bookmarks_users 1")
+}
+
+func TestMetricsBehindProxy(t *testing.T) {
+	t.Parallel()
+
+	// requests through a proxy on the same host come from a loopback address
+	throughProxy := func(t *testing.T, client *testClient, headers map[string]string) int {
+		t.Helper()
+
+		target, err := url.Parse(client.server.URL)
+		assert.Nil(t, err)
+
+		proxy := httptest.NewServer(httputil.NewSingleHostReverseProxy(target))
+		t.Cleanup(proxy.Close)
+
+		req, err := http.NewRequest(http.MethodGet, proxy.URL+"/metrics", nil) //nolint:noctx
+		assert.Nil(t, err)
+
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+
+		resp, err := client.client.Do(req)
+		assert.Nil(t, err)
+
+		defer resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	// by default no addresses are allowed, so only the token is accepted
+	client, _, _ := newTestApp(t, func(app *App) {
+		app.Metrics = NewMetrics(app.Links, app.Users, config.MakeConfig().Metrics)
+	})
+	assert.Equal(t, http.StatusForbidden, throughProxy(t, client, nil))
+
+	// allowing loopback addresses only lets in local clients if the proxy is trusted
+	client, _, _ = newTestApp(t, func(app *App) {
+		app.Metrics = NewMetrics(app.Links, app.Users, config.Metrics{Allow: []string{"127.0.0.1"}, Token: "token"})
+		app.TrustedProxies = config.Server{TrustedProxies: []string{"127.0.0.1"}}.TrustedNetworks() //nolint:exhaustruct
+	})
+	assert.Equal(t, http.StatusForbidden, throughProxy(t, client, map[string]string{"X-Forwarded-For": "203.0.113.1"}))
+	assert.Equal(t, http.StatusOK, throughProxy(t, client, map[string]string{"Authorization": "Bearer token"}))
+	assert.Equal(t, http.StatusOK, throughProxy(t, client, nil))
+}
+
+func TestRequestLogging(t *testing.T) {
+	t.Parallel()
+
+	client, _, _ := newTestApp(t)
+	client.login("alice@example.com", "password")
+
+	resp, _ := client.do(http.MethodGet, "/links/", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var entry map[string]any
+
+	for _, line := range strings.Split(strings.TrimSpace(client.logs.String()), "\n") {
+		assert.Nil(t, json.Unmarshal([]byte(line), &entry))
+
+		if entry["msg"] == "request" && entry["path"] == "/links/" {
+			break
+		}
+	}
+
+	assert.Equal(t, "/links/", entry["path"])
+	assert.Equal(t, "alice", entry["user"])
+	assert.EqualValues(t, http.StatusOK, entry["status"])
+	assert.NotEmpty(t, entry["request_id"])
+}
+
+func TestSlowQueryLogging(t *testing.T) {
+	t.Parallel()
+
+	// every query counts as slow
+	db := testStore.db.Session(&gorm.Session{Logger: database.NewSlowQueryLogger(time.Nanosecond)}) //nolint:exhaustruct
+
+	client, _, _ := newTestApp(t, func(app *App) {
+		app.Links = NewGormStore(db)
+	})
+	client.login("alice@example.com", "password")
+
+	resp, _ := client.do(http.MethodGet, "/links/", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var slowQuery, request map[string]any
+
+	for _, line := range strings.Split(strings.TrimSpace(client.logs.String()), "\n") {
+		var entry map[string]any
+		assert.Nil(t, json.Unmarshal([]byte(line), &entry))
+
+		switch {
+		case entry["msg"] == "slow query" && slowQuery == nil:
+			slowQuery = entry
+		case entry["msg"] == "request" && entry["path"] == "/links/":
+			request = entry
+		}
+	}
+
+	assert.NotNil(t, slowQuery)
+	assert.NotEmpty(t, slowQuery["sql"])
+	assert.Equal(t, "alice", slowQuery["user"])
+	assert.NotEmpty(t, slowQuery["request_id"])
+	assert.Equal(t, request["request_id"], slowQuery["request_id"])
+}
+
+func itoa(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
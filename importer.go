@@ -1,13 +1,20 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"time"
 )
 
-var errUnhandledDate = errors.New("unhandled date type")
+var (
+	errUnhandledDate = errors.New("unhandled date type")
+	errMissingURL    = errors.New("missing URL")
+	errImportFailed  = errors.New("import failed")
+)
 
 func parseJSONDate(input interface{}) (*time.Time, error) {
 	if dateInt, ok := input.(float64); ok {
@@ -36,6 +43,16 @@ func mergeStringField(orig string, field interface{}, changed bool) (string, boo
 	return orig, changed
 }
 
+func mergeIntField(orig int, field interface{}, changed bool) (int, bool) {
+	if repl, ok := field.(float64); ok {
+		if repl > 0 && int(repl) != orig {
+			return int(repl), true
+		}
+	}
+
+	return orig, changed
+}
+
 func mergeDateField(orig time.Time, field interface{}, changed bool) (time.Time, bool) {
 	if repl, err := parseJSONDate(field); err == nil {
 		if !orig.Equal(*repl) && (orig.Unix() < 1 || repl.Unix() > 0) {
@@ -46,21 +63,82 @@ func mergeDateField(orig time.Time, field interface{}, changed bool) (time.Time,
 	return orig, changed
 }
 
-func importer(url string, data map[string]interface{}) {
-	link := GetLinkByURL(url)
+// importLinks imports a JSON array of links, logging any rows that fail.
+func importLinks(links linkBackend, input io.Reader) error {
+	var data []map[string]interface{}
+
+	if err := json.NewDecoder(input).Decode(&data); err != nil {
+		return fmt.Errorf("could not parse import data: %w", err)
+	}
+
+	failures := make(map[int]error)
+
+	for row, item := range data {
+		url, ok := item["URL"].(string)
+		if !ok {
+			failures[row] = errMissingURL
+
+			continue
+		}
+
+		if err := importer(links, url, item); err != nil {
+			failures[row] = fmt.Errorf("%s: %w", url, err)
+		}
+	}
+
+	log.Printf("imported %d rows, %d failed", len(data)-len(failures), len(failures))
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	for row := range data {
+		if err, ok := failures[row]; ok {
+			log.Printf("row %d: %s", row+1, err)
+		}
+	}
+
+	return fmt.Errorf("%w: %d rows", errImportFailed, len(failures))
+}
+
+// importer creates or updates the link for url from a row of imported data.
+func importer(links linkBackend, url string, data map[string]interface{}) error {
+	result, err := importLink(links, url, data)
+	if err != nil {
+		result = "failed"
+	}
+
+	importedLinks.WithLabelValues(result).Inc()
+
+	return err
+}
+
+// importLink reports whether the link was saved, unchanged, or skipped.
+func importLink(links linkBackend, url string, data map[string]interface{}) (string, error) {
+	linkURL, err := parseURL(url)
+	if err != nil {
+		return "", err
+	}
+
+	link, err := links.GetLinkByURL(url)
 
 	changed := false
 
-	if link.ID == 0 {
-		link.URL = parseURL(url)
+	switch {
+	case errors.Is(err, errLinkNotFound):
+		tl := make(TagList)
+		link = &Link{URL: linkURL, Tags: &tl} //nolint:exhaustruct
 		changed = true
-
+	case err != nil:
+		return "", err //nolint:wrapcheck
+	case link.Tags == nil:
 		tl := make(TagList)
 		link.Tags = &tl
 	}
 
 	link.Title, changed = mergeStringField(link.Title, data["Title"], changed)
 	link.Description, changed = mergeStringField(link.Description, data["Description"], changed)
+	link.WordCount, changed = mergeIntField(link.WordCount, data["WordCount"], changed)
 
 	link.ReadAt, changed = mergeDateField(link.ReadAt, data["ReadAt"], changed)
 	link.SavedAt, changed = mergeDateField(link.SavedAt, data["SavedAt"], changed)
@@ -69,10 +147,18 @@ func importer(url string, data map[string]interface{}) {
 		link.Tags.Merge(NewTagListFromString(tagsStr))
 	}
 
-	if changed {
-		_, err := link.Save()
-		if err != nil {
-			log.Fatalf("could not save link %q: %s", link.URL, err)
-		}
+	if !changed {
+		return "unchanged", nil
 	}
+
+	err = links.SaveLink(link, RevisionSourceImport)
+	if errors.Is(err, errLinkTrashed) {
+		slog.Info("skipping link in the trash", "url", url)
+
+		return "skipped", nil
+	} else if err != nil {
+		return "", fmt.Errorf("could not save link: %w", err)
+	}
+
+	return "saved", nil
 }
@@ -4,27 +4,75 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/benjamineskola/bookmarks/config"
 	"github.com/benjamineskola/bookmarks/database"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
 )
 
-var errIncompatibleType = errors.New("incompatible type")
+var (
+	errIncompatibleType = errors.New("incompatible type")
+	errLinkNotFound     = errors.New("link not found")
+)
 
+// TagList is stored as an array literal, "{a,b,"c d"}", which is the native text[] encoding in
+// PostgreSQL and a plain string in SQLite.
 type TagList map[string]struct{}
 
+// NewTagListFromString parses an array literal; the surrounding braces are optional.
 func NewTagListFromString(src string) TagList {
-	trimmed := strings.Trim(src, "{}")
-	tags := strings.Split(trimmed, ",")
+	src = strings.TrimSpace(src)
+	src = strings.TrimPrefix(src, "{")
+	src = strings.TrimSuffix(src, "}")
+
+	tl := make(TagList)
+
+	var (
+		tag     strings.Builder
+		quoted  bool
+		inQuote bool
+		escaped bool
+	)
+
+	finish := func() {
+		value := tag.String()
+		if !quoted {
+			value = strings.TrimSpace(value)
+		}
+
+		if value != "" && (quoted || !strings.EqualFold(value, "NULL")) {
+			tl[value] = struct{}{}
+		}
+
+		tag.Reset()
+
+		quoted = false
+	}
 
-	tl := make(TagList, len(tags))
-	for _, tag := range tags {
-		tl[tag] = struct{}{}
+	for _, char := range src {
+		switch {
+		case escaped:
+			tag.WriteRune(char)
+
+			escaped = false
+		case char == '\\':
+			escaped = true
+		case char == '"':
+			inQuote = !inQuote
+			quoted = true
+		case char == ',' && !inQuote:
+			finish()
+		default:
+			tag.WriteRune(char)
+		}
 	}
 
+	finish()
+
 	return tl
 }
 
@@ -32,6 +80,10 @@ func (tl *TagList) Scan(src any) error {
 	switch source := src.(type) {
 	case string:
 		*tl = NewTagListFromString(source)
+	case []byte:
+		*tl = NewTagListFromString(string(source))
+	case nil:
+		*tl = make(TagList)
 	default:
 		return errIncompatibleType
 	}
@@ -39,15 +91,38 @@ func (tl *TagList) Scan(src any) error {
 	return nil
 }
 
-func (tl TagList) Value() (driver.Value, error) { //nolint:unparam
+// Sorted returns the tags in alphabetical order.
+func (tl TagList) Sorted() []string {
 	tags := make([]string, 0, len(tl))
 	for tag := range tl {
 		tags = append(tags, tag)
 	}
 
+	sort.Strings(tags)
+
+	return tags
+}
+
+func (tl TagList) Value() (driver.Value, error) { //nolint:unparam
+	tags := tl.Sorted()
+	for i, tag := range tags {
+		tags[i] = quoteArrayElement(tag)
+	}
+
 	return fmt.Sprintf("{%s}", strings.Join(tags, ",")), nil
 }
 
+// quoteArrayElement quotes a tag if it would otherwise be misread as part of the array syntax.
+func quoteArrayElement(tag string) string {
+	if tag != "" && !strings.EqualFold(tag, "NULL") && !strings.ContainsAny(tag, "{}\",\\ \t\n\r") {
+		return tag
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	return `"` + replacer.Replace(tag) + `"`
+}
+
 func (tl *TagList) Merge(other TagList) {
 	for tag := range other {
 		(*tl)[tag] = struct{}{}
@@ -57,6 +132,7 @@ func (tl *TagList) Merge(other TagList) {
 type Link struct {
 	gorm.Model
 
+	UserID      uint
 	URL         *datatypes.URL
 	Title       string
 	Description string
@@ -64,11 +140,56 @@ type Link struct {
 	ReadAt      time.Time
 	Public      bool
 	Tags        *TagList
+	// Priority moves the link up the unread queue; see priorityNames.
+	Priority int
+	// WordCount gives the link's reading time; 0 means it isn't known.
+	WordCount int
+}
+
+// wordsPerMinute is the reading speed used to estimate reading times.
+const wordsPerMinute = 230
+
+// priorityNames names the priorities a link can have, from the default upwards.
+var priorityNames = []string{"normal", "high", "urgent"} //nolint:gochecknoglobals
+
+var errInvalidPriority = errors.New("invalid priority")
+
+func validPriority(priority int) error {
+	if priority < 0 || priority >= len(priorityNames) {
+		return fmt.Errorf("%w %d: must be between 0 and %d", errInvalidPriority, priority, len(priorityNames)-1)
+	}
+
+	return nil
 }
 
-func NewLink(urlString string, title string, description string, public bool) *Link {
+func (l Link) PriorityName() string {
+	if validPriority(l.Priority) != nil {
+		return ""
+	}
+
+	return priorityNames[l.Priority]
+}
+
+// readingMinutes estimates how long it takes to read the given number of words, rounding up.
+func readingMinutes(words int64) int64 {
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}
+
+// ReadingMinutes estimates the link's reading time, or returns 0 if its word count isn't known.
+func (l Link) ReadingMinutes() int64 {
+	return readingMinutes(int64(l.WordCount))
+}
+
+// NewLink is for URLs known to be valid, and panics if urlString isn't; parse URLs from input with parseURL.
+func NewLink(userID uint, urlString string, title string, description string, public bool) *Link {
+	linkURL, err := parseURL(urlString)
+	if err != nil {
+		panic(err)
+	}
+
 	link := Link{ //nolint:exhaustruct
-		URL:         parseURL(urlString),
+		UserID:      userID,
+		URL:         linkURL,
 		Title:       title,
 		Description: description,
 		Public:      public,
@@ -77,7 +198,106 @@ func NewLink(urlString string, title string, description string, public bool) *L
 	return &link
 }
 
-func GetLinks(page int, count int, onlyPublic bool, onlyRead bool) (*[]Link, int64) {
+// hasTag matches links with the tag, which for SQLite means finding it as an element of the array literal.
+// The dialect is the query's own rather than the configured one, so it suits whichever store runs it.
+func hasTag(tag string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if database.Dialect(db.Dialector.Name()) == database.Postgres {
+			return db.Where("? = ANY(tags)", tag)
+		}
+
+		return db.Where("instr(',' || trim(tags, '{}') || ',', ?) > 0", ","+quoteArrayElement(tag)+",")
+	}
+}
+
+func matchingText(search string) func(*gorm.DB) *gorm.DB {
+	escaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	pattern := "%" + escaper.Replace(strings.ToLower(search)) + "%"
+
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(
+			`(lower(title) LIKE ? ESCAPE '\' OR lower(url) LIKE ? ESCAPE '\' OR lower(description) LIKE ? ESCAPE '\')`,
+			pattern, pattern, pattern,
+		)
+	}
+}
+
+// filteredLinks selects the user's links, or every user's if userID is 0, that match the filter.
+func (s *GormStore) filteredLinks(userID uint, filter LinkFilter) *gorm.DB {
+	query := s.db.Model(&Link{}) //nolint:exhaustruct
+
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	if filter.OnlyPublic {
+		query = query.Where("public = ?", true)
+	}
+
+	if filter.OnlyRead {
+		query = query.Where("read_at >= ?", time.Unix(0, 0))
+	}
+
+	if filter.OnlyUnread {
+		query = query.Where("(read_at IS NULL OR read_at < ?)", time.Unix(0, 0))
+	}
+
+	if filter.Tag != "" {
+		query = query.Scopes(hasTag(filter.Tag))
+	}
+
+	if filter.Search != "" {
+		query = query.Scopes(matchingText(filter.Search))
+	}
+
+	if filter.URL != "" {
+		query = query.Scopes(matchingURL(filter.URL))
+	}
+
+	return query
+}
+
+// linkSorts are the fields links can be sorted by, and the SQL for each. Sorting by the URL without its scheme
+// sorts by host, and then by path.
+var linkSorts = map[string]string{ //nolint:gochecknoglobals
+	"saved":   "saved_at",
+	"read":    "read_at",
+	"title":   "lower(title)",
+	"host":    "replace(replace(url, 'https://', ''), 'http://', '')",
+	"updated": "updated_at",
+}
+
+// linkSortNames are the keys of linkSorts, in the order they are offered.
+var linkSortNames = []string{"saved", "read", "title", "host", "updated"} //nolint:gochecknoglobals
+
+var errInvalidSort = errors.New("sort must be one of saved, read, title, host or updated")
+
+// sortField returns the field the filter sorts by, filling in the default.
+func (f LinkFilter) sortField() string {
+	switch {
+	case f.Sort != "":
+		return f.Sort
+	case f.OnlyRead:
+		return "read"
+	default:
+		return "saved"
+	}
+}
+
+// ascendingByDefault reports whether the links are sorted in ascending order unless asked otherwise: titles and
+// hosts from A to Z, and the unread queue oldest first.
+func (f LinkFilter) ascendingByDefault() bool {
+	switch f.Sort {
+	case "title", "host":
+		return true
+	case "":
+		return f.OnlyUnread
+	default:
+		return false
+	}
+}
+
+func (s *GormStore) GetLinks(userID uint, filter LinkFilter, page int, count int) (*[]Link, int64) {
 	var links []Link
 
 	if page < 1 {
@@ -85,51 +305,118 @@ func GetLinks(page int, count int, onlyPublic bool, onlyRead bool) (*[]Link, int
 	}
 
 	if count < 1 {
-		count = 50
+		count = config.DefaultPageSize
 	}
 
 	offset := (page - 1) * count
 
-	query := database.DB
+	query := s.filteredLinks(userID, filter)
 
-	if onlyPublic {
-		query = query.Where("public = ?", true)
+	var totalCount int64
+
+	query.Count(&totalCount)
+
+	direction := " desc"
+	if filter.Ascending {
+		direction = " asc"
 	}
 
-	if onlyRead {
-		query = query.Where("read_at >= ?", time.Unix(0, 0)).Order("read_at desc")
-	} else {
-		query = query.Order("saved_at desc")
+	if filter.Sort == "" && filter.OnlyUnread {
+		query = query.Order("priority desc")
 	}
 
-	var totalCount int64
+	query = query.Order(linkSorts[filter.sortField()] + direction).Order("id" + direction)
 
-	query.Model(&Link{}).Count(&totalCount) //nolint:exhaustruct
-	query = query.Limit(count).Offset(offset)
-	query.Find(&links)
+	query.Limit(count).Offset(offset).Find(&links)
 
 	return &links, totalCount
 }
 
-func GetLinkByID(id uint) *Link {
-	var link Link
+func (s *GormStore) GetLastModified(userID uint) (time.Time, int64) {
+	owned := func(db *gorm.DB) *gorm.DB {
+		db = db.Model(&Link{}).Unscoped() //nolint:exhaustruct
+		if userID != 0 {
+			db = db.Where("user_id = ?", userID)
+		}
 
-	database.DB.First(&link, id)
+		return db
+	}
 
-	return &link
+	var (
+		count                    int64
+		lastUpdated, lastDeleted Link
+	)
+
+	s.db.Scopes(owned).Count(&count)
+	s.db.Scopes(owned).Select("updated_at").Order("updated_at desc").Limit(1).Find(&lastUpdated)
+	// deleting a link only sets deleted_at
+	s.db.Scopes(owned).Select("deleted_at").Where("deleted_at IS NOT NULL").
+		Order("deleted_at desc").Limit(1).Find(&lastDeleted)
+
+	if lastDeleted.DeletedAt.Time.After(lastUpdated.UpdatedAt) {
+		return lastDeleted.DeletedAt.Time, count
+	}
+
+	return lastUpdated.UpdatedAt, count
+}
+
+// GetWordCount adds up the word counts of the links matching the filter, and counts how many have one.
+func (s *GormStore) GetWordCount(userID uint, filter LinkFilter) (int64, int64) {
+	var result struct {
+		Words   int64
+		Counted int64
+	}
+
+	s.filteredLinks(userID, filter).Where("word_count > 0").
+		Select("coalesce(sum(word_count), 0) AS words, count(*) AS counted").Scan(&result)
+
+	return result.Words, result.Counted
 }
 
-func GetLinkByURL(url string) *Link {
+// GetLinkByID returns the user's link with the given ID, or errLinkNotFound.
+func (s *GormStore) GetLinkByID(userID uint, id uint) (*Link, error) {
 	var link Link
 
-	normalisedURL := normaliseURLString(url)
+	err := s.db.Where("user_id = ?", userID).First(&link, id).Error
 
-	urlWithSlash := normalisedURL + "/"
-	urlWithoutSlash := strings.TrimRight(normalisedURL, "/")
+	return &link, linkLookupError(err)
+}
 
-	database.DB.Where("url = ? or url = ? or url = ?", normalisedURL, urlWithSlash, urlWithoutSlash).First(&link)
+// matchingURLs returns the forms of a URL which are treated as the same link: normalised, and with or
+// without a trailing slash. A URL which can't be parsed only matches itself.
+func matchingURLs(url string) []string {
+	normalisedURL, err := normaliseURLString(url)
+	if err != nil {
+		return []string{url}
+	}
 
-	return &link
+	return []string{normalisedURL, normalisedURL + "/", strings.TrimRight(normalisedURL, "/")}
+}
+
+func matchingURL(url string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("url in ?", matchingURLs(url))
+	}
+}
+
+// GetLinkByURL returns the user's link matching the given URL once normalised, or errLinkNotFound.
+func (s *GormStore) GetLinkByURL(userID uint, url string) (*Link, error) {
+	var link Link
+
+	err := s.db.Where("user_id = ?", userID).Scopes(matchingURL(url)).First(&link).Error
+
+	return &link, linkLookupError(err)
+}
+
+func linkLookupError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return errLinkNotFound
+	default:
+		return fmt.Errorf("could not load link: %w", err)
+	}
 }
 
 func (l Link) IsRead() bool {
@@ -140,8 +427,23 @@ func (l Link) HasReadDate() bool {
 	return l.ReadAt.Unix() > 0
 }
 
-func (l Link) Save() (uint, error) {
-	result := database.DB.Save(&l)
+func (s *GormStore) SaveLink(link *Link, source RevisionSource) (uint, error) {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		return saveWithRevision(tx, link, source)
+	})
 
-	return l.ID, result.Error
+	return link.ID, err //nolint:wrapcheck
+}
+
+func (s *GormStore) DeleteLink(userID uint, id uint) error {
+	result := s.db.Where("user_id = ?", userID).Delete(&Link{}, id) //nolint:exhaustruct
+	if result.Error != nil {
+		return fmt.Errorf("could not delete link: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return errLinkNotFound
+	}
+
+	return nil
 }
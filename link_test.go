@@ -1,41 +1,73 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/benjamineskola/bookmarks/config"
 	"github.com/benjamineskola/bookmarks/database"
 	"github.com/stretchr/testify/assert"
 )
 
+var (
+	testStore *GormStore //nolint:gochecknoglobals
+	testUser  *User      //nolint:gochecknoglobals
+)
+
 func TestMain(m *testing.M) {
-	os.Setenv("ENVIRONMENT", "test")
+	config.Config = config.MakeConfig()
+	config.Config.Environment = "test"
+	config.Config.Database.URL = os.Getenv("TEST_DATABASE_URL")
+	config.Config.URLNormalisations.AddWWW = []string{"theguardian.com"}
+	config.SetNormalisations(config.Config.URLNormalisations)
 
-	database.DB = database.InitDatabase()
+	db, err := database.InitDatabase()
+	if err != nil {
+		log.Fatalf("failed to open database: %s", err)
+	}
 
-	err := database.RunMigrations()
+	testStore = NewGormStore(db)
+
+	err = database.RunMigrations()
 	if err != nil {
 		log.Fatalf("failed to migrate database: %s", err)
 	}
 
-	database.DB.Exec("DELETE FROM links")
+	cleanDatabase()
 
-	config.Config = config.MakeConfig()
-	config.Config.URLNormalisations.AddWWW = []string{"theguardian.com"}
+	testUser, err = NewUser("test", "test@example.com", "password")
+	if err != nil {
+		log.Fatalf("failed to create user: %s", err)
+	}
+
+	if err := testStore.CreateUser(testUser); err != nil {
+		log.Fatalf("%s", err)
+	}
 
 	result := m.Run()
 
-	database.DB.Exec("DELETE FROM links")
+	cleanDatabase()
 
 	os.Exit(result)
 }
 
+// cleanDatabase empties the tables in an order which respects foreign keys.
+func cleanDatabase() {
+	for _, table := range []string{
+		"link_revisions", "links", "sessions", "recovery_codes", "failed_logins", "users",
+	} {
+		testStore.db.Exec("DELETE FROM " + table)
+	}
+}
+
 func TestLink(t *testing.T) {
 	t.Parallel()
 
-	link := NewLink("http://example.com/", "Example Website", "TestLink example", false)
+	link := NewLink(testUser.ID, "http://example.com/", "Example Website", "TestLink example", false)
 
 	assert.Equal(t, "http://example.com/", link.URL.String())
 }
@@ -43,18 +75,37 @@ func TestLink(t *testing.T) {
 func TestLinkTags(t *testing.T) {
 	t.Parallel()
 
-	link := NewLink("http://example.com/", "Example Website", "TestLinkTags example", false)
+	link := NewLink(testUser.ID, "http://example.com/", "Example Website", "TestLinkTags example", false)
 	tags := map[string]struct{}{"foo": {}, "bar": {}}
 	tl := TagList(tags)
 	link.Tags = &tl
 
-	id, _ := link.Save()
+	id, _ := testStore.SaveLink(link, RevisionSourceCLI)
+
+	actual, err := testStore.GetLinkByID(testUser.ID, id)
+	assert.NoError(t, err)
 
-	actual := GetLinkByID(id)
 	expected := TagList(map[string]struct{}{"foo": {}, "bar": {}})
 	assert.Equal(t, &expected, actual.Tags)
 }
 
+func TestTagListRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	tags := TagList{"foo": {}, "with space": {}, `quote"d`: {}, "comma,ed": {}, "NULL": {}}
+
+	value, err := tags.Value()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"NULL","comma,ed",foo,"quote\"d","with space"}`, value)
+
+	var scanned TagList
+	assert.NoError(t, scanned.Scan([]byte(value.(string)))) //nolint:forcetypeassert
+	assert.Equal(t, tags, scanned)
+
+	assert.Equal(t, TagList{"a": {}, "b": {}}, NewTagListFromString("{a, b,NULL}"))
+	assert.Equal(t, TagList{}, NewTagListFromString("{}"))
+}
+
 func TestGetLinkByURLNormalises(t *testing.T) {
 	t.Parallel()
 
@@ -67,8 +118,8 @@ func TestGetLinkByURLNormalises(t *testing.T) {
 		{Input: "https://nottheguardian.com", Expected: ""},
 	}
 
-	link := NewLink("https://www.theguardian.com", "Example Website", "TestGetLinkByURLNormalises example", false)
-	_, err := link.Save()
+	link := NewLink(testUser.ID, "https://www.theguardian.com", "Example Website", "TestGetLinkByURLNormalises example", false)
+	_, err := testStore.SaveLink(link, RevisionSourceCLI)
 	assert.Nil(t, err)
 
 	for _, testCase := range testCases {
@@ -76,11 +127,12 @@ func TestGetLinkByURLNormalises(t *testing.T) {
 		t.Run(testCase.Input, func(t *testing.T) {
 			t.Parallel()
 
-			actual := GetLinkByURL(testCase.Input)
+			actual, err := testStore.GetLinkByURL(testUser.ID, testCase.Input)
 
 			if testCase.Expected == "" {
-				assert.Nil(t, actual.URL)
+				assert.ErrorIs(t, err, errLinkNotFound)
 			} else {
+				assert.NoError(t, err)
 				assert.NotNil(t, actual.URL)
 				assert.Equal(t, testCase.Expected, actual.URL.String())
 			}
@@ -101,14 +153,14 @@ func TestGetLinkByURLNormalisesSlashes(t *testing.T) {
 		{Input: "https://normaliseslashwithout.com/", Expected: "https://normaliseslashwithout.com"},
 	}
 
-	link := NewLink("https://normaliseslashwith.com/",
+	link := NewLink(testUser.ID, "https://normaliseslashwith.com/",
 		"Example Website", "TestGetLinkByURLNormalisesSlashes example", false)
-	_, err := link.Save()
+	_, err := testStore.SaveLink(link, RevisionSourceCLI)
 	assert.Nil(t, err)
 
-	link = NewLink("https://normaliseslashwithout.com",
+	link = NewLink(testUser.ID, "https://normaliseslashwithout.com",
 		"Example Website", "TestGetLinkByURLNormalisesSlashes example", false)
-	_, err = link.Save()
+	_, err = testStore.SaveLink(link, RevisionSourceCLI)
 	assert.Nil(t, err)
 
 	for _, testCase := range testCases {
@@ -116,10 +168,378 @@ func TestGetLinkByURLNormalisesSlashes(t *testing.T) {
 		t.Run(testCase.Input, func(t *testing.T) {
 			t.Parallel()
 
-			actual := GetLinkByURL(testCase.Input)
+			actual, err := testStore.GetLinkByURL(testUser.ID, testCase.Input)
 
-			assert.NotNil(t, actual.URL)
+			assert.NoError(t, err)
 			assert.Equal(t, testCase.Expected, actual.URL.String())
 		})
 	}
 }
+
+func TestLinksAreScopedToUser(t *testing.T) {
+	t.Parallel()
+
+	otherUser, err := NewUser("other", "other@example.com", "password")
+	assert.Nil(t, err)
+	assert.Nil(t, testStore.CreateUser(otherUser))
+
+	link := NewLink(otherUser.ID, "https://scopedtouser.com/",
+		"Example Website", "TestLinksAreScopedToUser example", false)
+	id, err := testStore.SaveLink(link, RevisionSourceCLI)
+	assert.Nil(t, err)
+
+	actual, err := testStore.GetLinkByID(otherUser.ID, id)
+	assert.NoError(t, err)
+	assert.Equal(t, id, actual.ID)
+
+	_, err = testStore.GetLinkByID(testUser.ID, id)
+	assert.ErrorIs(t, err, errLinkNotFound)
+
+	_, err = testStore.GetLinkByURL(testUser.ID, "https://scopedtouser.com/")
+	assert.ErrorIs(t, err, errLinkNotFound)
+
+	link = NewLink(testUser.ID, "https://scopedtouser.com/",
+		"Example Website", "TestLinksAreScopedToUser example", false)
+	_, err = testStore.SaveLink(link, RevisionSourceCLI)
+	assert.Nil(t, err)
+}
+
+func TestGetLinksFilter(t *testing.T) {
+	t.Parallel()
+
+	user, err := NewUser("filter", "filter@example.com", "password")
+	assert.Nil(t, err)
+	assert.Nil(t, testStore.CreateUser(user))
+
+	for _, link := range []*Link{
+		NewLink(user.ID, "https://filter.example.com/go", "Learning Go", "", true),
+		NewLink(user.ID, "https://filter.example.com/rust", "Learning Rust", "100% safe", false),
+		NewLink(user.ID, "https://filter.example.com/tagged", "Tagged", "", false),
+	} {
+		tags := NewTagListFromString("programming, two words")
+		if link.Title == "Learning Rust" {
+			tags = NewTagListFromString("programming")
+		}
+
+		link.Tags = &tags
+		_, err := testStore.SaveLink(link, RevisionSourceCLI)
+		assert.Nil(t, err)
+	}
+
+	titles := func(filter LinkFilter) []string {
+		links, total := testStore.GetLinks(user.ID, filter, 1, 10)
+		result := make([]string, 0, len(*links))
+
+		for _, link := range *links {
+			result = append(result, link.Title)
+		}
+
+		assert.EqualValues(t, len(result), total)
+		sort.Strings(result)
+
+		return result
+	}
+
+	assert.Equal(t, []string{"Learning Go", "Learning Rust"}, titles(LinkFilter{Search: "LEARNING"}))  //nolint:exhaustruct
+	assert.Equal(t, []string{"Learning Rust"}, titles(LinkFilter{Search: "100%"}))                     //nolint:exhaustruct
+	assert.Empty(t, titles(LinkFilter{Search: "_"}))                                                   //nolint:exhaustruct
+	assert.Equal(t, []string{"Learning Go", "Tagged"}, titles(LinkFilter{Tag: "two words"}))           //nolint:exhaustruct
+	assert.Len(t, titles(LinkFilter{Tag: "programming"}), 3)                                           //nolint:exhaustruct
+	assert.Empty(t, titles(LinkFilter{Tag: "program"}))                                                //nolint:exhaustruct
+	assert.Equal(t, []string{"Learning Go"}, titles(LinkFilter{Tag: "programming", OnlyPublic: true})) //nolint:exhaustruct
+}
+
+func TestGetLinksSort(t *testing.T) {
+	t.Parallel()
+
+	user, err := NewUser("sort", "sort@example.com", "password")
+	assert.Nil(t, err)
+	assert.Nil(t, testStore.CreateUser(user))
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, link := range []*Link{
+		NewLink(user.ID, "https://b.example.com/", "banana", "", false),
+		NewLink(user.ID, "http://c.example.com/", "Apple", "", false),
+		NewLink(user.ID, "https://a.example.com/", "cherry", "", false),
+	} {
+		link.SavedAt = start.Add(time.Duration(i) * time.Hour)
+		_, err := testStore.SaveLink(link, RevisionSourceCLI)
+		assert.Nil(t, err)
+	}
+
+	titles := func(filter LinkFilter) []string {
+		links, _ := testStore.GetLinks(user.ID, filter, 1, 10)
+		result := make([]string, 0, len(*links))
+
+		for _, link := range *links {
+			result = append(result, link.Title)
+		}
+
+		return result
+	}
+
+	ascending := func(sort string) LinkFilter {
+		return LinkFilter{Sort: sort, Ascending: true} //nolint:exhaustruct
+	}
+
+	assert.Equal(t, []string{"cherry", "Apple", "banana"}, titles(LinkFilter{})) //nolint:exhaustruct
+	assert.Equal(t, []string{"Apple", "banana", "cherry"}, titles(ascending("title")))
+	assert.Equal(t, []string{"cherry", "banana", "Apple"}, titles(ascending("host")))
+	assert.Equal(t, []string{"banana", "Apple", "cherry"}, titles(ascending("saved")))
+}
+
+func TestGetLinksFrom(t *testing.T) {
+	t.Parallel()
+
+	user, err := NewUser("cursor", "cursor@example.com", "password")
+	assert.Nil(t, err)
+	assert.Nil(t, testStore.CreateUser(user))
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	save := func(title string, savedAt time.Time) {
+		link := NewLink(user.ID, "https://cursor.example.com/"+title, title, "", false)
+		link.SavedAt = savedAt
+		_, err := testStore.SaveLink(link, RevisionSourceCLI)
+		assert.Nil(t, err)
+	}
+
+	// two links saved at the same time are told apart by ID
+	for i, title := range []string{"a", "b", "c", "d"} {
+		save(title, start.Add(time.Duration(min(i, 2))*time.Hour))
+	}
+
+	titles := func(links *[]Link) []string {
+		result := make([]string, 0, len(*links))
+		for _, link := range *links {
+			result = append(result, link.Title)
+		}
+
+		return result
+	}
+
+	filter := LinkFilter{} //nolint:exhaustruct
+	links, total, more := testStore.GetLinksFrom(user.ID, filter, nil, 2)
+	assert.Equal(t, []string{"d", "c"}, titles(links))
+	assert.Equal(t, int64(4), total)
+	assert.True(t, more)
+
+	// a newer link doesn't move the next page
+	save("e", start.Add(time.Hour*10))
+
+	next := &LinkCursor{SavedAt: (*links)[1].SavedAt, ID: (*links)[1].ID, Before: false}
+	links, _, more = testStore.GetLinksFrom(user.ID, filter, next, 2)
+	assert.Equal(t, []string{"b", "a"}, titles(links))
+	assert.False(t, more)
+
+	prev := &LinkCursor{SavedAt: (*links)[0].SavedAt, ID: (*links)[0].ID, Before: true}
+	links, _, more = testStore.GetLinksFrom(user.ID, filter, prev, 2)
+	assert.Equal(t, []string{"d", "c"}, titles(links))
+	assert.True(t, more)
+
+	filter.Ascending = true
+	links, _, _ = testStore.GetLinksFrom(user.ID, filter, next, 2)
+	assert.Equal(t, []string{"d", "e"}, titles(links))
+}
+
+func TestUnreadQueue(t *testing.T) {
+	t.Parallel()
+
+	user, err := NewUser("queue", "queue@example.com", "password")
+	assert.Nil(t, err)
+	assert.Nil(t, testStore.CreateUser(user))
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, title := range []string{"Oldest", "Urgent", "Newest", "Read"} {
+		link := NewLink(user.ID, "https://queue.example.com/"+title, title, "", false)
+		link.SavedAt = start.Add(time.Duration(i) * time.Hour)
+		link.WordCount = 300 * i
+
+		switch title {
+		case "Urgent":
+			link.Priority = 2
+		case "Read":
+			link.ReadAt = time.Now()
+		}
+
+		_, err := testStore.SaveLink(link, RevisionSourceCLI)
+		assert.Nil(t, err)
+	}
+
+	titles := func(filter LinkFilter) []string {
+		links, _ := testStore.GetLinks(user.ID, filter, 1, 10)
+		result := make([]string, 0, len(*links))
+
+		for _, link := range *links {
+			result = append(result, link.Title)
+		}
+
+		return result
+	}
+
+	queue := LinkFilter{OnlyUnread: true} //nolint:exhaustruct
+	assert.Equal(t, []string{"Urgent", "Newest", "Oldest"}, titles(queue))
+
+	queue.Ascending = true
+	assert.Equal(t, []string{"Urgent", "Oldest", "Newest"}, titles(queue))
+
+	words, counted := testStore.GetWordCount(user.ID, queue)
+	assert.Equal(t, int64(900), words)
+	assert.Equal(t, int64(2), counted)
+	assert.Equal(t, int64(4), readingMinutes(words))
+}
+
+func TestTrash(t *testing.T) {
+	t.Parallel()
+
+	link := NewLink(testUser.ID, "https://trashed.com/", "Example Website", "TestTrash example", false)
+	id, err := testStore.SaveLink(link, RevisionSourceCLI)
+	assert.Nil(t, err)
+
+	assert.Nil(t, testStore.DeleteLink(testUser.ID, id))
+
+	_, err = testStore.GetLinkByID(testUser.ID, id)
+	assert.ErrorIs(t, err, errLinkNotFound)
+	assert.Equal(t, id, testStore.GetTrashedLinkByURL(testUser.ID, "https://trashed.com").ID)
+
+	count, err := testStore.RestoreLinks(testUser.ID, []uint{id})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+
+	_, err = testStore.GetLinkByID(testUser.ID, id)
+	assert.NoError(t, err)
+
+	// links not in the trash can't be purged
+	count, err = testStore.PurgeLinks(testUser.ID, []uint{id})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+
+	assert.Nil(t, testStore.DeleteLink(testUser.ID, id))
+
+	count, err = testStore.PurgeLinks(testUser.ID, []uint{id})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, uint(0), testStore.GetTrashedLinkByURL(testUser.ID, "https://trashed.com").ID)
+}
+
+func TestGetLastModified(t *testing.T) {
+	t.Parallel()
+
+	user, err := NewUser("modified", "modified@example.com", "password")
+	assert.Nil(t, err)
+	assert.Nil(t, testStore.CreateUser(user))
+
+	lastModified, count := testStore.GetLastModified(user.ID)
+	assert.True(t, lastModified.IsZero())
+	assert.Equal(t, int64(0), count)
+
+	link := NewLink(user.ID, "https://modified.example.com/", "", "", true)
+	_, err = testStore.SaveLink(link, RevisionSourceCLI)
+	assert.Nil(t, err)
+
+	saved, count := testStore.GetLastModified(user.ID)
+	assert.False(t, saved.IsZero())
+	assert.Equal(t, int64(1), count)
+
+	// links in the trash still count, and deleting one is a change
+	time.Sleep(10 * time.Millisecond)
+	assert.Nil(t, testStore.DeleteLink(user.ID, link.ID))
+
+	deleted, count := testStore.GetLastModified(user.ID)
+	assert.True(t, deleted.After(saved))
+	assert.Equal(t, int64(1), count)
+}
+
+func TestPurgeTrashPeriodicallyStops(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		purgeTrashPeriodically(ctx, NewMemoryStore(), time.Hour)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("purging the trash didn't stop")
+	}
+}
+
+func TestUpdateLinks(t *testing.T) {
+	t.Parallel()
+
+	tags := NewTagListFromString("bulk,old")
+	first := NewLink(testUser.ID, "https://bulk.com/1", "First", "TestUpdateLinks example", false)
+	first.Tags = &tags
+	firstID, err := testStore.SaveLink(first, RevisionSourceCLI)
+	assert.Nil(t, err)
+
+	secondID, err := testStore.SaveLink(NewLink(testUser.ID, "https://bulk.com/2", "Second", "", false), RevisionSourceCLI)
+	assert.Nil(t, err)
+
+	read := true
+	change := BulkChange{AddTags: []string{"new"}, RemoveTags: []string{"old"}, Read: &read} //nolint:exhaustruct
+
+	// nothing is changed if any of the links is missing
+	_, err = testStore.UpdateLinks(testUser.ID, []uint{firstID, secondID, 0}, change, RevisionSourceCLI)
+	assert.ErrorIs(t, err, errLinkNotFound)
+
+	link, err := testStore.GetLinkByID(testUser.ID, firstID)
+	assert.Nil(t, err)
+	assert.False(t, link.IsRead())
+
+	count, err := testStore.UpdateLinks(testUser.ID, []uint{firstID, secondID, firstID}, change, RevisionSourceCLI)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+
+	link, err = testStore.GetLinkByID(testUser.ID, firstID)
+	assert.Nil(t, err)
+	assert.True(t, link.IsRead())
+	assert.Equal(t, []string{"bulk", "new"}, link.Tags.Sorted())
+	assert.Equal(t, RevisionSourceCLI, testStore.GetLinkRevisions(firstID)[0].Source)
+
+	link, err = testStore.GetLinkByID(testUser.ID, secondID)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"new"}, link.Tags.Sorted())
+
+	_, err = testStore.UpdateLinks(testUser.ID, []uint{firstID, secondID}, BulkChange{Delete: true}, //nolint:exhaustruct
+		RevisionSourceCLI)
+	assert.Nil(t, err)
+	assert.Equal(t, secondID, testStore.GetTrashedLinkByURL(testUser.ID, "https://bulk.com/2").ID)
+}
+
+func TestLinkRevisions(t *testing.T) {
+	t.Parallel()
+
+	link := NewLink(testUser.ID, "https://revisions.com/", "Original title", "TestLinkRevisions example", false)
+	id, err := testStore.SaveLink(link, RevisionSourceWeb)
+	assert.Nil(t, err)
+
+	link, err = testStore.GetLinkByID(testUser.ID, id)
+	assert.Nil(t, err)
+
+	link.Title = "Imported title"
+	_, err = testStore.SaveLink(link, RevisionSourceImport)
+	assert.Nil(t, err)
+
+	revisions := testStore.GetLinkRevisions(id)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, RevisionSourceImport, revisions[0].Source)
+	assert.Equal(t, FieldChange{Old: "Original title", New: "Imported title"}, revisions[0].Changes["Title"])
+
+	link, err = testStore.GetLinkByID(testUser.ID, id)
+	assert.Nil(t, err)
+	assert.Nil(t, RevertLink(testStore, link, revisions[1].ID, RevisionSourceWeb))
+
+	link, err = testStore.GetLinkByID(testUser.ID, id)
+	assert.Nil(t, err)
+	assert.Equal(t, "Original title", link.Title)
+	assert.Len(t, testStore.GetLinkRevisions(id), 3)
+}
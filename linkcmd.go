@@ -0,0 +1,520 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/benjamineskola/bookmarks/config"
+)
+
+var (
+	errUnknownFormat = errors.New("unknown output format")
+	errNoUser        = errors.New("--user is required unless there is exactly one user")
+	errNoSuchUser    = errors.New("no such user")
+	errBothFlags     = errors.New("flags can't be used together")
+)
+
+// linkBackend is what the link commands work with: the local database, or a server's API.
+type linkBackend interface {
+	// ListLinks returns up to limit links matching the filter, or all of them if limit is 0.
+	ListLinks(filter LinkFilter, limit int) ([]Link, error)
+	GetLinkByID(id uint) (*Link, error)
+	GetLinkByURL(url string) (*Link, error)
+	// SaveLink creates or updates the link, refusing to create one whose URL is in the trash with errLinkTrashed.
+	SaveLink(link *Link, source RevisionSource) error
+	// DeleteLink moves the link to the trash.
+	DeleteLink(id uint) error
+	// UpdateLinks makes the change to all of the links, or to none of them if any can't be changed.
+	UpdateLinks(ids []uint, change BulkChange) (int64, error)
+}
+
+// localLinks works with a user's links in the local database.
+type localLinks struct {
+	store LinkStore
+	user  *User
+}
+
+func (l localLinks) ListLinks(filter LinkFilter, limit int) ([]Link, error) {
+	pageSize := config.Config.Pagination.PageSize
+	result := make([]Link, 0)
+
+	for page := 1; ; page++ {
+		links, total := l.store.GetLinks(l.user.ID, filter, page, pageSize)
+		result = append(result, *links...)
+
+		if int64(page*pageSize) >= total || (limit > 0 && len(result) >= limit) {
+			break
+		}
+	}
+
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+func (l localLinks) GetLinkByID(id uint) (*Link, error) {
+	return l.store.GetLinkByID(l.user.ID, id) //nolint:wrapcheck
+}
+
+func (l localLinks) GetLinkByURL(url string) (*Link, error) {
+	return l.store.GetLinkByURL(l.user.ID, url) //nolint:wrapcheck
+}
+
+// SaveLink gives new links the user and, as the server does, the current time if they have no saved time.
+func (l localLinks) SaveLink(link *Link, source RevisionSource) error {
+	if link.ID == 0 {
+		if l.store.GetTrashedLinkByURL(l.user.ID, link.URL.String()).ID != 0 {
+			return errLinkTrashed
+		}
+
+		link.UserID = l.user.ID
+	}
+
+	if link.SavedAt.IsZero() {
+		link.SavedAt = time.Now()
+	}
+
+	_, err := l.store.SaveLink(link, source)
+
+	return err //nolint:wrapcheck
+}
+
+func (l localLinks) DeleteLink(id uint) error {
+	return l.store.DeleteLink(l.user.ID, id) //nolint:wrapcheck
+}
+
+func (l localLinks) UpdateLinks(ids []uint, change BulkChange) (int64, error) {
+	return l.store.UpdateLinks(l.user.ID, ids, change, RevisionSourceCLI) //nolint:wrapcheck
+}
+
+// backendFlag adds --user to a command, returning a function that opens the server's API if client.server is
+// set, and otherwise the local database as the given user, who can be left out if there is only one.
+// An email address passed to the function takes the place of --user.
+func backendFlag(flags *flag.FlagSet) func(email string) (linkBackend, error) {
+	userEmail := flags.String("user", os.Getenv("BOOKMARKS_USER"),
+		"email address of the user, for the local database (env BOOKMARKS_USER)")
+
+	return func(email string) (linkBackend, error) {
+		if client := config.Config.Client; client.Server != "" {
+			if email != "" {
+				return nil, errUserOnLocal
+			}
+
+			return newRemoteLinks(client.Server, client.Token), nil
+		}
+
+		if email == "" {
+			email = *userEmail
+		}
+
+		store := NewGormStore(openDatabase())
+
+		if email != "" {
+			user := store.GetUserByEmail(email)
+			if user.ID == 0 {
+				return nil, fmt.Errorf("%w: %q", errNoSuchUser, email)
+			}
+
+			return localLinks{store: store, user: user}, nil
+		}
+
+		users := store.GetUsers()
+		if len(users) != 1 {
+			return nil, errNoUser
+		}
+
+		return localLinks{store: store, user: &users[0]}, nil
+	}
+}
+
+// findLink looks up a link by ID, or else by URL.
+func findLink(links linkBackend, idOrURL string) (*Link, error) {
+	if id, err := strconv.ParseUint(idOrURL, 10, 0); err == nil {
+		return links.GetLinkByID(uint(id))
+	}
+
+	return links.GetLinkByURL(idOrURL)
+}
+
+func formatDate(t time.Time) string {
+	switch {
+	case t.IsZero():
+		return "-"
+	case t.Unix() <= 0:
+		// read at an unknown time
+		return "yes"
+	default:
+		return t.Format(time.DateOnly)
+	}
+}
+
+func writeLinks(output io.Writer, format string, links []Link) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(links) //nolint:wrapcheck
+	case "tsv":
+		fmt.Fprintln(output, "id\tsaved\tread\tpublic\turl\ttitle\ttags")
+
+		for _, link := range links {
+			fmt.Fprintf(output, "%d\t%s\t%s\t%t\t%s\t%s\t%s\n", link.ID, formatDate(link.SavedAt),
+				formatDate(link.ReadAt), link.Public, link.URL, tsvField(link.Title), tsvField(tagString(link)))
+		}
+
+		return nil
+	case "table":
+		writer := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0) //nolint:gomnd
+		fmt.Fprintln(writer, "ID\tSAVED\tREAD\tURL\tTITLE")
+
+		for _, link := range links {
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\n",
+				link.ID, formatDate(link.SavedAt), formatDate(link.ReadAt), link.URL, link.Title)
+		}
+
+		return writer.Flush() //nolint:wrapcheck
+	default:
+		return fmt.Errorf("%w %q: use table, json or tsv", errUnknownFormat, format)
+	}
+}
+
+func tsvField(value string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(value)
+}
+
+func tagString(link Link) string {
+	if link.Tags == nil {
+		return ""
+	}
+
+	return strings.Join(link.Tags.Sorted(), ",")
+}
+
+func writeLink(output io.Writer, format string, link *Link) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(link) //nolint:wrapcheck
+	case "text":
+		writer := tabwriter.NewWriter(output, 0, 0, 1, ' ', 0)
+		fmt.Fprintf(writer, "ID:\t%d\n", link.ID)
+		fmt.Fprintf(writer, "URL:\t%s\n", link.URL)
+		fmt.Fprintf(writer, "Title:\t%s\n", link.Title)
+		fmt.Fprintf(writer, "Description:\t%s\n", link.Description)
+		fmt.Fprintf(writer, "Tags:\t%s\n", tagString(*link))
+		fmt.Fprintf(writer, "Public:\t%t\n", link.Public)
+		fmt.Fprintf(writer, "Saved:\t%s\n", formatDate(link.SavedAt))
+		fmt.Fprintf(writer, "Read:\t%s\n", formatDate(link.ReadAt))
+
+		return writer.Flush() //nolint:wrapcheck
+	default:
+		return fmt.Errorf("%w %q: use text or json", errUnknownFormat, format)
+	}
+}
+
+// listFlags adds the flags shared by list and search.
+func listFlags(flags *flag.FlagSet, filter *LinkFilter) (*int, *string) {
+	flags.BoolVar(&filter.OnlyPublic, "public", false, "only public links")
+	flags.BoolVar(&filter.OnlyRead, "read", false, "only links that have been read, most recently read first")
+	flags.BoolVar(&filter.OnlyUnread, "unread", false, "only unread links, as a queue: by priority, then oldest first")
+	flags.StringVar(&filter.Tag, "tag", "", "only links with this tag")
+	limit := flags.Int("limit", 0, "show at most this many links (default all)")
+	format := flags.String("format", "table", "output format: table, json or tsv")
+
+	return limit, format
+}
+
+func listCommand(flags *flag.FlagSet) func(args []string) error {
+	openLinks := backendFlag(flags)
+	filter := LinkFilter{} //nolint:exhaustruct
+	limit, format := listFlags(flags, &filter)
+	flags.StringVar(&filter.Search, "search", "", "only links whose title, URL or description contain this")
+
+	return exactArgs(0, func([]string) error {
+		filter.Ascending = filter.ascendingByDefault()
+
+		return listLinks(openLinks, filter, *limit, *format)
+	})
+}
+
+func searchCommand(flags *flag.FlagSet) func(args []string) error {
+	openLinks := backendFlag(flags)
+	filter := LinkFilter{} //nolint:exhaustruct
+	limit, format := listFlags(flags, &filter)
+
+	return func(args []string) error {
+		if len(args) == 0 {
+			return errUsage
+		}
+
+		filter.Search = strings.Join(args, " ")
+		filter.Ascending = filter.ascendingByDefault()
+
+		return listLinks(openLinks, filter, *limit, *format)
+	}
+}
+
+func listLinks(openLinks func(string) (linkBackend, error), filter LinkFilter, limit int, format string) error {
+	links, err := openLinks("")
+	if err != nil {
+		return err
+	}
+
+	result, err := links.ListLinks(filter, limit)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	return writeLinks(os.Stdout, format, result)
+}
+
+func showCommand(flags *flag.FlagSet) func(args []string) error {
+	openLinks := backendFlag(flags)
+	format := flags.String("format", "text", "output format: text or json")
+
+	return exactArgs(1, func(args []string) error {
+		links, err := openLinks("")
+		if err != nil {
+			return err
+		}
+
+		link, err := findLink(links, args[0])
+		if err != nil {
+			return err
+		}
+
+		return writeLink(os.Stdout, *format, link)
+	})
+}
+
+func saveCommand(flags *flag.FlagSet) func(args []string) error {
+	openLinks := backendFlag(flags)
+	title := flags.String("title", "", "title of the link")
+	description := flags.String("description", "", "description of the link")
+	tags := make([]string, 0)
+	flags.Func("tag", "add a tag (can be repeated)", func(tag string) error {
+		tags = append(tags, tag)
+
+		return nil
+	})
+	public := flags.Bool("public", false, "make the link public")
+
+	return exactArgs(1, func(args []string) error {
+		links, err := openLinks("")
+		if err != nil {
+			return err
+		}
+
+		linkURL, err := parseURL(args[0])
+		if err != nil {
+			return err
+		}
+
+		link, err := links.GetLinkByURL(args[0])
+		if errors.Is(err, errLinkNotFound) {
+			link = &Link{URL: linkURL} //nolint:exhaustruct
+		} else if err != nil {
+			return err
+		}
+
+		flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "title":
+				link.Title = *title
+			case "description":
+				link.Description = *description
+			case "public":
+				link.Public = *public
+			}
+		})
+
+		if link.Tags == nil {
+			tl := make(TagList)
+			link.Tags = &tl
+		}
+
+		for _, tag := range tags {
+			link.Tags.Merge(NewTagListFromString(tag))
+		}
+
+		if err := links.SaveLink(link, RevisionSourceCLI); err != nil {
+			return err
+		}
+
+		return writeLink(os.Stdout, "text", link)
+	})
+}
+
+// updateLinkCommand makes a command which changes a link identified by ID or URL.
+func updateLinkCommand(update func(links linkBackend, link *Link) error) func(*flag.FlagSet) func([]string) error {
+	return func(flags *flag.FlagSet) func([]string) error {
+		openLinks := backendFlag(flags)
+
+		return exactArgs(1, func(args []string) error {
+			links, err := openLinks("")
+			if err != nil {
+				return err
+			}
+
+			link, err := findLink(links, args[0])
+			if err != nil {
+				return err
+			}
+
+			return update(links, link)
+		})
+	}
+}
+
+func markRead(links linkBackend, link *Link) error {
+	if link.IsRead() {
+		return nil
+	}
+
+	link.ReadAt = time.Now()
+
+	return links.SaveLink(link, RevisionSourceCLI)
+}
+
+func markUnread(links linkBackend, link *Link) error {
+	if !link.IsRead() {
+		return nil
+	}
+
+	link.ReadAt = time.Time{}
+
+	return links.SaveLink(link, RevisionSourceCLI)
+}
+
+func removeLink(links linkBackend, link *Link) error {
+	if err := links.DeleteLink(link.ID); err != nil {
+		return err
+	}
+
+	fmt.Printf("moved link %d to the trash\n", link.ID)
+
+	return nil
+}
+
+// addCommand imports links from JSON, for the user given either by EMAIL or by --user.
+func addCommand(flags *flag.FlagSet) func(args []string) error {
+	openLinks := backendFlag(flags)
+
+	return func(args []string) error {
+		if len(args) > 1 {
+			return errUsage
+		}
+
+		email := ""
+		if len(args) == 1 {
+			email = args[0]
+		}
+
+		links, err := openLinks(email)
+		if err != nil {
+			return err
+		}
+
+		return importLinks(links, os.Stdin)
+	}
+}
+
+// eitherFlag combines a pair of opposite flags into a change, which is nil if neither was given.
+func eitherFlag(yes bool, no bool, names string) (*bool, error) {
+	switch {
+	case yes && no:
+		return nil, fmt.Errorf("%w: %s", errBothFlags, names)
+	case yes || no:
+		return &yes, nil
+	default:
+		return nil, nil //nolint:nilnil
+	}
+}
+
+// bulkCommand changes all the links matching a search at once.
+func bulkCommand(flags *flag.FlagSet) func(args []string) error {
+	openLinks := backendFlag(flags)
+	change := BulkChange{} //nolint:exhaustruct
+	flags.Func("add-tag", "add a tag (can be repeated)", func(tag string) error {
+		change.AddTags = append(change.AddTags, tag)
+
+		return nil
+	})
+	flags.Func("remove-tag", "remove a tag (can be repeated)", func(tag string) error {
+		change.RemoveTags = append(change.RemoveTags, tag)
+
+		return nil
+	})
+	read := flags.Bool("read", false, "mark the links as read")
+	unread := flags.Bool("unread", false, "mark the links as unread")
+	public := flags.Bool("public", false, "make the links public")
+	private := flags.Bool("private", false, "make the links private")
+	flags.BoolVar(&change.Delete, "delete", false, "move the links to the trash")
+	dryRun := flags.Bool("dry-run", false, "list the links which would be changed, without changing them")
+
+	return func(args []string) error {
+		if len(args) == 0 {
+			return errUsage
+		}
+
+		var err error
+		if change.Read, err = eitherFlag(*read, *unread, "--read and --unread"); err != nil {
+			return err
+		}
+
+		if change.Public, err = eitherFlag(*public, *private, "--public and --private"); err != nil {
+			return err
+		}
+
+		if change.isEmpty() && !*dryRun {
+			return errNoBulkChange
+		}
+
+		links, err := openLinks("")
+		if err != nil {
+			return err
+		}
+
+		matching, err := links.ListLinks(LinkFilter{Search: strings.Join(args, " ")}, 0) //nolint:exhaustruct
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		if len(matching) == 0 {
+			fmt.Println("no links match the search")
+
+			return nil
+		}
+
+		if *dryRun {
+			return writeLinks(os.Stdout, "table", matching)
+		}
+
+		ids := make([]uint, 0, len(matching))
+		for _, link := range matching {
+			ids = append(ids, link.ID)
+		}
+
+		count, err := links.UpdateLinks(ids, change)
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		fmt.Printf("changed %d links\n", count)
+
+		return nil
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/benjamineskola/bookmarks/config"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// setupLogging sends all logging, including the standard log package's, through slog in the configured format.
+func setupLogging(conf config.Log, output io.Writer) {
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(conf.Level)) // already validated
+
+	options := &slog.HandlerOptions{Level: level} //nolint:exhaustruct
+
+	var handler slog.Handler
+	if conf.Format == "json" {
+		handler = slog.NewJSONHandler(output, options)
+	} else {
+		handler = slog.NewTextHandler(output, options)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	log.SetFlags(0)
+}
+
+// requestLog holds the logger for a request, which gains attributes, such as the user, as the request is handled.
+type requestLog struct {
+	logger atomic.Pointer[slog.Logger]
+}
+
+// Logger returns the request's logger as it is now.
+func (l *requestLog) Logger() *slog.Logger {
+	return l.logger.Load()
+}
+
+// logger returns the request's logger, which identifies the request and the user making it.
+func logger(r *http.Request) *slog.Logger {
+	if entry, ok := r.Context().Value("logger").(*requestLog); ok {
+		return entry.Logger()
+	}
+
+	return slog.Default()
+}
+
+// addLogAttrs adds attributes to every later log line for the request, including the access log.
+func addLogAttrs(r *http.Request, args ...any) {
+	if entry, ok := r.Context().Value("logger").(*requestLog); ok {
+		entry.logger.Store(entry.logger.Load().With(args...))
+	}
+}
+
+// logRequests gives each request a logger carrying its ID, and logs the request once it's been handled.
+func logRequests(base *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		entry := &requestLog{} //nolint:exhaustruct
+		entry.logger.Store(base.With("request_id", middleware.GetReqID(r.Context())))
+
+		ctx := context.WithValue(r.Context(), "logger", entry) //nolint:revive,staticcheck
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if strings.HasPrefix(r.URL.Path, "/static/") || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			level = slog.LevelDebug
+		}
+
+		entry.logger.Load().Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+			"remote_ip", remoteIP(r),
+		)
+	})
+}
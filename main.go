@@ -1,138 +1,172 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/BurntSushi/toml"
+	"github.com/benjamineskola/bookmarks/config"
 	"github.com/benjamineskola/bookmarks/database"
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
-	"github.com/gorilla/csrf"
+	"gorm.io/gorm"
 )
 
-func serve() { //nolint:funlen
-	router := chi.NewRouter()
-	router.Use(middleware.RequestID)
-	router.Use(middleware.Logger)
-	router.Use(middleware.GetHead)
-	router.Use(middleware.Recoverer)
-	router.Use(middleware.Compress(5))
+var errMigrationsPending = errors.New("database migrations are not up to date")
 
-	host := os.Getenv("HOST")
-	port := os.Getenv("PORT")
-	csrfKey := []byte(os.Getenv("CSRF_KEY"))
+// configPollInterval is how often config.toml is checked for changes to the URL normalisation rules.
+const configPollInterval = 5 * time.Second
 
-	if host == "" {
-		host = "0.0.0.0"
+func serve(loader *config.Loader) {
+	conf := config.Config
+	if err := conf.ValidateServer(); err != nil {
+		log.Fatalf("%s", err)
 	}
 
-	if port == "" {
-		port = "8080"
+	if conf.Database.AutoMigrate {
+		runMigrations()
 	}
 
-	if len(csrfKey) == 0 {
-		log.Fatalf("No CSRF_KEY set")
-	} else if len(csrfKey) != 32 {
-		log.Fatalf("CSRF_KEY must be 32 bytes")
+	db := openDatabase()
+	store := NewGormStore(db)
+
+	if err := instrumentDatabase(db); err != nil {
+		log.Fatalf("failed to instrument database: %s", err)
 	}
 
-	csrfMiddleware := csrf.Protect(csrfKey,
-		csrf.Secure(host != "127.0.0.1"),
-		csrf.Path("/"),
-	)
+	secretKey := []byte(conf.Server.SecretKey)
+	secure := conf.Server.Host != "127.0.0.1"
 
-	router.Use(csrfMiddleware)
+	app := &App{
+		Links:        store,
+		Users:        store,
+		Sessions:     NewSessionManager(store, secretKey, conf.Session.IdleTimeout, conf.Session.MaxAge, secure),
+		LoginLimiter: NewRateLimiter(NewMemoryRateLimitStore(), realClock{}),
+		CSRFKey:      []byte(conf.Server.CSRFKey),
+		Secure:       secure,
 
-	router.Use(func(next http.Handler) http.Handler {
-		return http.TimeoutHandler(next, 10*time.Second, "Request timed out")
-	})
+		TrustedProxies: conf.Server.TrustedNetworks(),
 
-	database.DB = database.InitDatabase()
+		PageSize:       conf.Pagination.PageSize,
+		PublicProfiles: conf.Features.PublicProfiles,
 
-	router.Get("/auth/login/", loginFormHandler)
-	router.Post("/auth/login/", loginHandler)
-	router.Post("/auth/logout/", logoutHandler)
+		Metrics:    NewMetrics(store, store, conf.Metrics),
+		CheckReady: databaseReady(db),
+	}
 
-	router.Route("/links", func(router chi.Router) {
-		router.Use(middleware.URLFormat)
+	// the jobs stop when the server is asked to, and are waited for so that the database isn't closed under them
+	jobs, stopJobs := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopJobs()
 
-		router.Use(middleware.Maybe(middleware.WithValue("onlyPublic", true), func(r *http.Request) bool {
-			return !isAuthenticated(r)
-		},
-		))
+	var running sync.WaitGroup
 
-		router.Get("/", indexHandler)
-		router.With(middleware.WithValue("onlyPublic", true)).Route("/public", func(router chi.Router) {
-			router.Get("/", indexHandler)
-			router.Get("/page/{page}", indexHandler)
-		})
-		router.With(middleware.WithValue("onlyRead", true)).Route("/read", func(router chi.Router) {
-			router.Get("/", indexHandler)
-			router.Get("/page/{page}", indexHandler)
-		})
+	running.Add(1)
 
-		router.Get("/page/{page}", indexHandler)
+	go func() {
+		defer running.Done()
+		purgeTrashPeriodically(jobs, store, conf.Features.TrashRetention)
+	}()
 
-		router.Get("/new", formHandler)
-		router.Post("/", saveHandler)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 
-		router.Route("/{id}", func(router chi.Router) {
-			router.Use(rejectUnauthenticated)
+	go loader.WatchNormalisations(configPollInterval, reload)
 
-			router.Get("/", showHandler)
-			router.Put("/", saveHandler)
-			router.Post("/", saveHandler)
-			router.Delete("/", deleteHandler)
-			router.Get("/edit", formHandler)
-		})
-	})
+	if backup := conf.Backup; backup.Directory != "" && backup.Interval > 0 {
+		running.Add(1)
 
-	fs := http.FileServer(http.Dir("static"))
-	router.Handle("/static/*", http.StripPrefix("/static/", fs))
+		go func() {
+			defer running.Done()
+			backupPeriodically(jobs, db, backup)
+		}()
+	}
+
+	listener, err := listen(net.JoinHostPort(conf.Server.Host, strconv.Itoa(conf.Server.Port)))
+	if err != nil {
+		log.Fatalf("Failed to start server: %s", err)
+	}
 
-	log.Printf("listening on %s:%s", host, port)
+	slog.Info("listening", "address", listener.Addr().String())
 
 	server := &http.Server{ //nolint:exhaustruct
-		Addr:         fmt.Sprintf("%s:%s", host, port),
-		Handler:      router,
+		Handler:      app.Router(),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Failed to start server: %s", err)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	serverErr := runServer(server, listener, stop, conf.Server.ShutdownTimeout)
+
+	stopJobs()
+	running.Wait()
+
+	if sqlDB, err := db.DB(); err == nil {
+		if err := sqlDB.Close(); err != nil {
+			slog.Warn("could not close database", "error", err)
+		}
+	}
+
+	if serverErr != nil {
+		log.Fatalf("%s", serverErr)
 	}
+
+	slog.Info("stopped")
 }
 
-func add() {
-	database.DB = database.InitDatabase()
+// databaseReady returns a check that the database can be reached and has every migration applied. Once they all
+// are, later checks only ping the database, rather than reading the migrations again on every probe.
+func databaseReady(db *gorm.DB) func() error {
+	var migrated atomic.Bool
 
-	var data []map[string]interface{}
+	return func() error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return fmt.Errorf("database unavailable: %w", err)
+		}
 
-	dec := json.NewDecoder(os.Stdin)
-	_ = dec.Decode(&data)
+		if err := sqlDB.Ping(); err != nil {
+			return fmt.Errorf("database unreachable: %w", err)
+		}
 
-	for _, item := range data {
-		if url, ok := item["URL"].(string); ok {
-			importer(url, item)
+		if migrated.Load() {
+			return nil
 		}
+
+		status, err := database.GetMigrationStatus()
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		if status.Dirty || status.Pending > 0 {
+			return fmt.Errorf("%w (version %d, latest %d)", errMigrationsPending, status.Version, status.Latest)
+		}
+
+		migrated.Store(true)
+
+		return nil
 	}
 }
 
-func addUser(email string, password string) {
-	user, err := NewUser(email, password)
+func openDatabase() *gorm.DB {
+	db, err := database.InitDatabase()
 	if err != nil {
-		log.Fatalf("could not create user: %s", err)
+		log.Fatalf("%s", err)
 	}
 
-	database.DB = database.InitDatabase()
-
-	database.DB.Save(user)
+	return db
 }
 
 func runMigrations() {
@@ -142,25 +176,139 @@ func runMigrations() {
 	}
 }
 
+// migrateCommands are the subcommands of "bookmarks migrate", which by itself applies any pending migrations.
+func migrateCommands() []command {
+	return []command{
+		{name: "up", args: "", summary: "Apply any pending migrations.", setup: withoutFlags(exactArgs(0, migrateUp))},
+		{
+			name: "status", args: "", summary: "Show which migrations have been applied.",
+			setup: withoutFlags(exactArgs(0, migrateStatus)),
+		},
+		{name: "down", args: "N", summary: "Undo the last N migrations.", setup: withoutFlags(exactArgs(1, migrateDown))},
+		{
+			name: "force", args: "V", summary: "Record version V as applied, after fixing a failed migration.",
+			setup: withoutFlags(exactArgs(1, migrateForce)),
+		},
+	}
+}
+
+func migrateUp([]string) error {
+	if err := database.RunMigrations(); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return nil
+}
+
+func migrateStatus([]string) error {
+	status, err := database.GetMigrationStatus()
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	fmt.Printf("version: %d\n", status.Version)
+	fmt.Printf("latest: %d\n", status.Latest)
+	fmt.Printf("pending: %d\n", status.Pending)
+
+	if status.Dirty {
+		fmt.Println("dirty: the last migration failed; fix it and then use force")
+	}
+
+	return nil
+}
+
+func migrateDown(args []string) error {
+	steps, err := strconv.Atoi(args[0])
+	if err != nil || steps < 1 {
+		return errUsage
+	}
+
+	return database.MigrateDown(steps) //nolint:wrapcheck
+}
+
+func migrateForce(args []string) error {
+	version, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errUsage
+	}
+
+	return database.ForceMigration(version) //nolint:wrapcheck
+}
+
+func configShow([]string) error {
+	if err := toml.NewEncoder(os.Stdout).Encode(config.Current().Redacted()); err != nil {
+		return fmt.Errorf("could not show configuration: %w", err)
+	}
+
+	return nil
+}
+
+// commands lists the subcommands; serve is the default.
+func commands(loader *config.Loader) []command {
+	return []command{
+		{name: "serve", args: "", summary: "Run the web server.", setup: func(*flag.FlagSet) func([]string) error {
+			return exactArgs(0, func([]string) error {
+				serve(loader)
+
+				return nil
+			})
+		}},
+		{name: "list", args: "", summary: "List links.", setup: listCommand},
+		{name: "search", args: "QUERY...", summary: "List links matching a search.", setup: searchCommand},
+		{name: "show", args: "ID|URL", summary: "Show a link.", setup: showCommand},
+		{name: "save", args: "URL", summary: "Save a link, or update it if it's already saved.", setup: saveCommand},
+		{name: "read", args: "ID|URL", summary: "Mark a link as read.", setup: updateLinkCommand(markRead)},
+		{name: "unread", args: "ID|URL", summary: "Mark a link as unread.", setup: updateLinkCommand(markUnread)},
+		{name: "rm", args: "ID|URL", summary: "Move a link to the trash.", setup: updateLinkCommand(removeLink)},
+		{name: "bulk", args: "QUERY...", summary: "Change all the links matching a search.", setup: bulkCommand},
+		{name: "add", args: "[EMAIL]", summary: "Import links as JSON from standard input.", setup: addCommand},
+		{name: "user", args: "", summary: "Manage users.", subcommands: userCommands()},
+		{name: "trash", args: "", summary: "Manage links in the trash.", subcommands: trashCommands()},
+		{
+			name: "migrate", args: "", summary: "Manage database migrations.",
+			setup: withoutFlags(exactArgs(0, migrateUp)), subcommands: migrateCommands(),
+		},
+		{name: "backup", args: "PATH", summary: "Back up the database.", setup: withoutFlags(exactArgs(1, backupCommand))},
+		{
+			name: "restore", args: "PATH", summary: "Restore the database from a backup.",
+			setup: withoutFlags(exactArgs(1, restoreCommand)),
+		},
+		{
+			name: "config", args: "", summary: "Show the configuration.",
+			subcommands: []command{{
+				name: "show", args: "", summary: "Show the configuration, with secrets redacted.",
+				setup: withoutFlags(exactArgs(0, configShow)),
+			}},
+		},
+	}
+}
+
 func main() {
+	loader := config.RegisterFlags(flag.CommandLine)
+	commands := commands(loader)
+
+	flag.Usage = func() {
+		output := flag.CommandLine.Output()
+		printCommands(output, programName, commands)
+		fmt.Fprint(output, "\nflags:\n")
+		flag.PrintDefaults()
+	}
+
 	flag.Parse()
 	args := flag.Args()
 
-	cmd := "serve"
-	if len(args) > 0 {
-		cmd = args[0]
+	conf, err := loader.Load()
+	if err != nil {
+		log.Fatalf("%s", err)
 	}
 
-	switch cmd {
-	case "serve":
-		serve()
-	case "add":
-		add()
-	case "adduser":
-		addUser(args[1], args[2])
-	case "migrate":
-		runMigrations()
-	default:
-		log.Fatalf("unknown command %q", cmd)
+	config.Config = conf
+	setupLogging(conf.Log, os.Stderr)
+	config.SetNormalisations(conf.URLNormalisations)
+
+	if len(args) == 0 {
+		args = []string{"serve"}
 	}
+
+	os.Exit(runCommand(programName, commands, args))
 }
@@ -0,0 +1,697 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benjamineskola/bookmarks/config"
+	"gorm.io/gorm"
+)
+
+var errUnknownSession = errors.New("unknown session")
+
+// MemoryStore is an in-memory LinkStore and UserStore, so that handlers can be tested in isolation.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	nextID        uint
+	links         map[uint]Link
+	revisions     []LinkRevision
+	users         map[uint]User
+	sessions      map[uint]Session
+	recoveryCodes []RecoveryCode
+	failedLogins  []FailedLogin
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{ //nolint:exhaustruct
+		links:    make(map[uint]Link),
+		users:    make(map[uint]User),
+		sessions: make(map[uint]Session),
+	}
+}
+
+func (s *MemoryStore) newID() uint {
+	s.nextID++
+
+	return s.nextID
+}
+
+// copyLink returns a copy of the link that shares nothing with the original.
+func copyLink(link Link) Link {
+	if link.Tags != nil {
+		tags := make(TagList, len(*link.Tags))
+		tags.Merge(*link.Tags)
+		link.Tags = &tags
+	}
+
+	return link
+}
+
+// paginate sorts, counts and paginates links the same way the SQL queries do.
+func paginate(links []Link, page int, count int, less func(a, b Link) bool) (*[]Link, int64) {
+	if page < 1 {
+		page = 1
+	}
+
+	if count < 1 {
+		count = config.DefaultPageSize
+	}
+
+	sort.Slice(links, func(i, j int) bool { return less(links[i], links[j]) })
+
+	total := int64(len(links))
+	start := min((page-1)*count, len(links))
+	end := min(start+count, len(links))
+	result := links[start:end]
+
+	return &result, total
+}
+
+func (s *MemoryStore) filterLinks(include func(Link) bool) []Link {
+	links := make([]Link, 0)
+
+	for _, link := range s.links {
+		if include(link) {
+			links = append(links, copyLink(link))
+		}
+	}
+
+	return links
+}
+
+// matchingLinks returns the user's links matching the filter, in no particular order.
+func (s *MemoryStore) matchingLinks(userID uint, filter LinkFilter) []Link {
+	search := strings.ToLower(filter.Search)
+
+	return s.filterLinks(func(link Link) bool {
+		hasTag := false
+		if link.Tags != nil {
+			_, hasTag = (*link.Tags)[filter.Tag]
+		}
+
+		return !link.DeletedAt.Valid &&
+			(userID == 0 || link.UserID == userID) &&
+			(!filter.OnlyPublic || link.Public) &&
+			(!filter.OnlyRead || !link.ReadAt.Before(time.Unix(0, 0))) &&
+			(!filter.OnlyUnread || link.ReadAt.Before(time.Unix(0, 0))) &&
+			(filter.Tag == "" || hasTag) &&
+			(filter.URL == "" || slices.Contains(matchingURLs(filter.URL), link.URL.String())) &&
+			(search == "" || strings.Contains(strings.ToLower(link.Title), search) ||
+				strings.Contains(strings.ToLower(link.URL.String()), search) ||
+				strings.Contains(strings.ToLower(link.Description), search))
+	})
+}
+
+func (s *MemoryStore) GetLinks(userID uint, filter LinkFilter, pageNumber int, count int) (*[]Link, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	links := s.matchingLinks(userID, filter)
+
+	return paginate(links, pageNumber, count, func(a, b Link) bool {
+		if filter.Sort == "" && filter.OnlyUnread && a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+
+		order := compareLinks(a, b, filter.sortField())
+		if order == 0 {
+			order = cmp.Compare(a.ID, b.ID)
+		}
+
+		if filter.Ascending {
+			return order < 0
+		}
+
+		return order > 0
+	})
+}
+
+func (s *MemoryStore) GetLinksFrom(userID uint, filter LinkFilter, cursor *LinkCursor, count int,
+) (*[]Link, int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	links := s.matchingLinks(userID, filter)
+	total := int64(len(links))
+	backwards := cursor != nil && cursor.Before
+	ascending := filter.Ascending != backwards
+
+	// before reports whether a comes before b in the order the links are read in
+	before := func(a, b Link) bool {
+		order := a.SavedAt.Compare(b.SavedAt)
+		if order == 0 {
+			order = cmp.Compare(a.ID, b.ID)
+		}
+
+		return order != 0 && (order < 0) == ascending
+	}
+
+	if cursor != nil {
+		at := Link{SavedAt: cursor.SavedAt} //nolint:exhaustruct
+		at.ID = cursor.ID
+		links = slices.DeleteFunc(links, func(link Link) bool { return !before(at, link) })
+	}
+
+	sort.Slice(links, func(i, j int) bool { return before(links[i], links[j]) })
+
+	more := len(links) > count
+	if more {
+		links = links[:count]
+	}
+
+	if backwards {
+		slices.Reverse(links)
+	}
+
+	return &links, total, more
+}
+
+// compareLinks compares links by one of linkSorts, as the SQL does.
+func compareLinks(a Link, b Link, field string) int {
+	host := strings.NewReplacer("https://", "", "http://", "")
+
+	switch field {
+	case "read":
+		return a.ReadAt.Compare(b.ReadAt)
+	case "title":
+		return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+	case "host":
+		return strings.Compare(host.Replace(a.URL.String()), host.Replace(b.URL.String()))
+	case "updated":
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	default:
+		return a.SavedAt.Compare(b.SavedAt)
+	}
+}
+
+func (s *MemoryStore) GetLastModified(userID uint) (time.Time, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		latest time.Time
+		count  int64
+	)
+
+	for _, link := range s.links {
+		if userID != 0 && link.UserID != userID {
+			continue
+		}
+
+		count++
+
+		for _, changed := range []time.Time{link.UpdatedAt, link.DeletedAt.Time} {
+			if changed.After(latest) {
+				latest = changed
+			}
+		}
+	}
+
+	return latest, count
+}
+
+func (s *MemoryStore) GetWordCount(userID uint, filter LinkFilter) (int64, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var words, counted int64
+
+	for _, link := range s.matchingLinks(userID, filter) {
+		if link.WordCount > 0 {
+			words += int64(link.WordCount)
+			counted++
+		}
+	}
+
+	return words, counted
+}
+
+func (s *MemoryStore) GetLinkByID(userID uint, id uint) (*Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[id]
+	if !ok || link.UserID != userID || link.DeletedAt.Valid {
+		return &Link{}, errLinkNotFound //nolint:exhaustruct
+	}
+
+	link = copyLink(link)
+
+	return &link, nil
+}
+
+func (s *MemoryStore) findByURL(userID uint, url string, trashed bool) *Link {
+	urls := matchingURLs(url)
+
+	for _, link := range s.links {
+		if link.UserID != userID || link.DeletedAt.Valid != trashed || link.URL == nil {
+			continue
+		}
+
+		for _, candidate := range urls {
+			if link.URL.String() == candidate {
+				link = copyLink(link)
+
+				return &link
+			}
+		}
+	}
+
+	return &Link{} //nolint:exhaustruct
+}
+
+func (s *MemoryStore) GetLinkByURL(userID uint, url string) (*Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link := s.findByURL(userID, url, false)
+	if link.ID == 0 {
+		return link, errLinkNotFound
+	}
+
+	return link, nil
+}
+
+func (s *MemoryStore) SaveLink(link *Link, source RevisionSource) (uint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before := Link{} //nolint:exhaustruct
+	if link.ID != 0 {
+		before = s.links[link.ID]
+	} else {
+		link.ID = s.newID()
+		link.CreatedAt = time.Now()
+	}
+
+	link.UpdatedAt = time.Now()
+	s.links[link.ID] = copyLink(*link)
+
+	if changes := diffLinks(&before, link); len(changes) > 0 {
+		s.revisions = append(s.revisions, LinkRevision{ //nolint:exhaustruct
+			ID: s.newID(), CreatedAt: time.Now(), LinkID: link.ID, Source: source, Changes: changes,
+		})
+	}
+
+	return link.ID, nil
+}
+
+func (s *MemoryStore) DeleteLink(userID uint, id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[id]
+	if !ok || link.UserID != userID || link.DeletedAt.Valid {
+		return errLinkNotFound
+	}
+
+	link.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	s.links[id] = link
+
+	return nil
+}
+
+func (s *MemoryStore) UpdateLinks(userID uint, ids []uint, change BulkChange, source RevisionSource) (int64, error) {
+	ids = uniqueIDs(ids)
+
+	s.mu.Lock()
+
+	for _, id := range ids {
+		if link, ok := s.links[id]; !ok || link.UserID != userID || link.DeletedAt.Valid {
+			s.mu.Unlock()
+
+			return 0, errLinkNotFound
+		}
+	}
+
+	s.mu.Unlock()
+
+	for _, id := range ids {
+		link := copyLink(s.links[id])
+		change.apply(&link)
+
+		if _, err := s.SaveLink(&link, source); err != nil {
+			return 0, err
+		}
+
+		if change.Delete {
+			if err := s.DeleteLink(userID, id); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return int64(len(ids)), nil
+}
+
+func (s *MemoryStore) GetLinkRevisions(linkID uint) []LinkRevision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revisions := make([]LinkRevision, 0)
+
+	for i := len(s.revisions) - 1; i >= 0; i-- {
+		if s.revisions[i].LinkID == linkID {
+			revisions = append(revisions, s.revisions[i])
+		}
+	}
+
+	return revisions
+}
+
+func (s *MemoryStore) GetTrashedLinks(userID uint, pageNumber int, count int) (*[]Link, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	links := s.filterLinks(func(link Link) bool {
+		return link.DeletedAt.Valid && link.UserID == userID
+	})
+
+	return paginate(links, pageNumber, count, func(a, b Link) bool {
+		return a.DeletedAt.Time.After(b.DeletedAt.Time)
+	})
+}
+
+func (s *MemoryStore) GetTrashedLinkByURL(userID uint, url string) *Link {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.findByURL(userID, url, true)
+}
+
+func (s *MemoryStore) RestoreLinks(userID uint, ids []uint) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+
+	for _, id := range ids {
+		if link, ok := s.links[id]; ok && link.UserID == userID && link.DeletedAt.Valid {
+			link.DeletedAt = gorm.DeletedAt{} //nolint:exhaustruct
+			link.UpdatedAt = time.Now()
+			s.links[id] = link
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// purge permanently deletes the trashed links matching include, along with their history.
+func (s *MemoryStore) purge(include func(Link) bool) int64 {
+	var count int64
+
+	for id, link := range s.links {
+		if link.DeletedAt.Valid && include(link) {
+			delete(s.links, id)
+			count++
+		}
+	}
+
+	revisions := make([]LinkRevision, 0, len(s.revisions))
+
+	for _, revision := range s.revisions {
+		if _, ok := s.links[revision.LinkID]; ok {
+			revisions = append(revisions, revision)
+		}
+	}
+
+	s.revisions = revisions
+
+	return count
+}
+
+func (s *MemoryStore) PurgeLinks(userID uint, ids []uint) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	selected := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	return s.purge(func(link Link) bool { return link.UserID == userID && selected[link.ID] }), nil
+}
+
+func (s *MemoryStore) EmptyTrash(userID uint) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.purge(func(link Link) bool { return link.UserID == userID }), nil
+}
+
+func (s *MemoryStore) PurgeExpiredLinks(retention time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention)
+
+	return s.purge(func(link Link) bool { return link.DeletedAt.Time.Before(cutoff) }), nil
+}
+
+func (s *MemoryStore) findUser(matches func(User) bool) *User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if matches(user) {
+			return &user
+		}
+	}
+
+	return &User{} //nolint:exhaustruct
+}
+
+func (s *MemoryStore) GetUserByID(id uint) *User {
+	return s.findUser(func(user User) bool { return user.ID == id })
+}
+
+func (s *MemoryStore) GetUserByEmail(email string) *User {
+	return s.findUser(func(user User) bool { return normaliseEmail(user.Email) == normaliseEmail(email) })
+}
+
+func (s *MemoryStore) GetUserByName(name string) *User {
+	return s.findUser(func(user User) bool { return user.Name == name })
+}
+
+func (s *MemoryStore) GetUserByAPITokenHash(tokenHash string) *User {
+	return s.findUser(func(user User) bool { return tokenHash != "" && user.APITokenHash == tokenHash })
+}
+
+func (s *MemoryStore) GetUsers() []User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	return users
+}
+
+func (s *MemoryStore) CreateUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user.ID = s.newID()
+	user.CreatedAt = time.Now()
+	s.users[user.ID] = *user
+
+	return nil
+}
+
+// UpdateUser saves every field, which is indistinguishable from saving only the named ones as long as
+// callers don't hold stale copies.
+func (s *MemoryStore) UpdateUser(user *User, _ ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[user.ID] = *user
+
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, link := range s.links {
+		if link.UserID == user.ID {
+			link.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+			s.links[id] = link
+		}
+	}
+
+	s.purge(func(link Link) bool { return link.UserID == user.ID })
+
+	for id, session := range s.sessions {
+		if session.UserID == user.ID {
+			delete(s.sessions, id)
+		}
+	}
+
+	s.replaceRecoveryCodes(user.ID, nil)
+	delete(s.users, user.ID)
+
+	return nil
+}
+
+func (s *MemoryStore) LogFailedLogin(email string, ipAddress string, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failedLogins = append(s.failedLogins, FailedLogin{
+		ID: s.newID(), CreatedAt: time.Now(), Email: email, IPAddress: ipAddress, Reason: reason,
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) replaceRecoveryCodes(userID uint, codes []RecoveryCode) {
+	kept := make([]RecoveryCode, 0, len(s.recoveryCodes)+len(codes))
+
+	for _, code := range s.recoveryCodes {
+		if code.UserID != userID {
+			kept = append(kept, code)
+		}
+	}
+
+	for _, code := range codes {
+		code.ID = s.newID()
+		kept = append(kept, code)
+	}
+
+	s.recoveryCodes = kept
+}
+
+func (s *MemoryStore) ReplaceRecoveryCodes(userID uint, codes []RecoveryCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replaceRecoveryCodes(userID, codes)
+
+	return nil
+}
+
+func (s *MemoryStore) UseRecoveryCode(userID uint, codeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, code := range s.recoveryCodes {
+		if code.UserID == userID && code.CodeHash == codeHash && code.UsedAt == nil {
+			now := time.Now()
+			s.recoveryCodes[i].UsedAt = &now
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (s *MemoryStore) CreateSession(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session.ID = s.newID()
+	session.CreatedAt = time.Now()
+	s.sessions[session.ID] = *session
+
+	return nil
+}
+
+func (s *MemoryStore) GetSessionByTokenHash(tokenHash string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, session := range s.sessions {
+		if session.TokenHash == tokenHash {
+			return &session, nil
+		}
+	}
+
+	return nil, errUnknownSession
+}
+
+func (s *MemoryStore) TouchSession(session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.sessions[session.ID]; ok {
+		stored.LastSeenAt = session.LastSeenAt
+		stored.IPAddress = session.IPAddress
+		s.sessions[session.ID] = stored
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetSessionsForUser(userID uint) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := make([]Session, 0)
+
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+
+	return sessions
+}
+
+func (s *MemoryStore) deleteSessions(matches func(Session) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if matches(session) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func (s *MemoryStore) RevokeSession(userID uint, sessionID uint) error {
+	s.deleteSessions(func(session Session) bool { return session.UserID == userID && session.ID == sessionID })
+
+	return nil
+}
+
+func (s *MemoryStore) RevokeAllSessions(userID uint) error {
+	s.deleteSessions(func(session Session) bool { return session.UserID == userID })
+
+	return nil
+}
+
+func (s *MemoryStore) RevokeOtherSessions(userID uint, currentSessionID uint) error {
+	s.deleteSessions(func(session Session) bool {
+		return session.UserID == userID && session.ID != currentSessionID
+	})
+
+	return nil
+}
+
+func (s *MemoryStore) PurgeExpiredSessions(idleBefore time.Time, createdBefore time.Time) error {
+	s.deleteSessions(func(session Session) bool {
+		return session.LastSeenAt.Before(idleBefore) || session.CreatedAt.Before(createdBefore)
+	})
+
+	return nil
+}
+
+var (
+	_ LinkStore = (*MemoryStore)(nil)
+	_ UserStore = (*MemoryStore)(nil)
+)
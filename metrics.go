@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/benjamineskola/bookmarks/config"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+const queryStartKey = "metrics:query_start"
+
+// These are shared by every Metrics, as the jobs and the database aren't tied to a single router.
+var (
+	jobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{ //nolint:exhaustruct,gochecknoglobals
+		Name: "bookmarks_job_runs_total",
+		Help: "Background job runs by job and result.",
+	}, []string{"job", "result"})
+
+	importedLinks = prometheus.NewCounterVec(prometheus.CounterOpts{ //nolint:exhaustruct,gochecknoglobals
+		Name: "bookmarks_imported_links_total",
+		Help: "Imported rows by result: saved, unchanged, skipped or failed.",
+	}, []string{"result"})
+
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{ //nolint:exhaustruct,gochecknoglobals
+		Name:    "bookmarks_db_query_duration_seconds",
+		Help:    "Database query latency by operation.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+)
+
+func recordJob(job string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+
+	jobRuns.WithLabelValues(job, result).Inc()
+}
+
+// Metrics collects request metrics for a router, along with the shared metrics and totals from the stores.
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+
+	// the metrics are only served to clients from these networks, or presenting the token
+	allowed []*net.IPNet
+	token   string
+}
+
+func NewMetrics(links LinkStore, users UserStore, conf config.Metrics) *Metrics {
+	metrics := &Metrics{
+		allowed:  conf.AllowedNetworks(),
+		token:    conf.Token,
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{ //nolint:exhaustruct
+			Name: "bookmarks_http_requests_total",
+			Help: "HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{ //nolint:exhaustruct
+			Name:    "bookmarks_http_request_duration_seconds",
+			Help:    "HTTP request latency by method and route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+	}
+
+	metrics.registry.MustRegister(
+		metrics.requests,
+		metrics.latency,
+		jobRuns,
+		importedLinks,
+		queryDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{ //nolint:exhaustruct
+			Name: "bookmarks_links",
+			Help: "Links saved by all users, not counting the trash.",
+		}, func() float64 {
+			_, total := links.GetLinks(0, LinkFilter{}, 1, 1) //nolint:exhaustruct
+
+			return float64(total)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{ //nolint:exhaustruct
+			Name: "bookmarks_users",
+			Help: "Registered users.",
+		}, func() float64 {
+			return float64(len(users.GetUsers()))
+		}),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}), //nolint:exhaustruct
+	)
+
+	return metrics
+}
+
+// instrument records each request against the route pattern that matched it, so that IDs don't make new series.
+func (m *Metrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		m.requests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		m.latency.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+func (m *Metrics) allows(r *http.Request) bool {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && m.token != "" {
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(m.token)) == 1 {
+			return true
+		}
+	}
+
+	ip := net.ParseIP(remoteIP(r))
+
+	for _, network := range m.allowed {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !m.allows(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+
+		return
+	}
+
+	promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r) //nolint:exhaustruct
+}
+
+func startQueryTimer(tx *gorm.DB) {
+	tx.InstanceSet(queryStartKey, time.Now())
+}
+
+func observeQuery(operation string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		if start, ok := tx.InstanceGet(queryStartKey); ok {
+			queryDuration.WithLabelValues(operation).Observe(time.Since(start.(time.Time)).Seconds()) //nolint:forcetypeassert
+		}
+	}
+}
+
+// instrumentDatabase times every query made through db.
+func instrumentDatabase(db *gorm.DB) error {
+	callback := db.Callback()
+
+	return errors.Join(
+		callback.Create().Before("gorm:create").Register("metrics:before_create", startQueryTimer),
+		callback.Create().After("gorm:create").Register("metrics:after_create", observeQuery("create")),
+		callback.Query().Before("gorm:query").Register("metrics:before_query", startQueryTimer),
+		callback.Query().After("gorm:query").Register("metrics:after_query", observeQuery("query")),
+		callback.Update().Before("gorm:update").Register("metrics:before_update", startQueryTimer),
+		callback.Update().After("gorm:update").Register("metrics:after_update", observeQuery("update")),
+		callback.Delete().Before("gorm:delete").Register("metrics:before_delete", startQueryTimer),
+		callback.Delete().After("gorm:delete").Register("metrics:after_delete", observeQuery("delete")),
+		callback.Row().Before("gorm:row").Register("metrics:before_row", startQueryTimer),
+		callback.Row().After("gorm:row").Register("metrics:after_row", observeQuery("row")),
+		callback.Raw().Before("gorm:raw").Register("metrics:before_raw", startQueryTimer),
+		callback.Raw().After("gorm:raw").Register("metrics:after_raw", observeQuery("raw")),
+	)
+}
+
+func healthzHandler(w http.ResponseWriter, _ *http.Request) {
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (app *App) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if app.CheckReady != nil {
+		if err := app.CheckReady(); err != nil {
+			logger(r).Warn("not ready", "error", err)
+			http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+
+			return
+		}
+	}
+
+	_, _ = w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migrations so that the binary can run them from anywhere.
+// Each supported database has its own directory, with matching version numbers.
+package migrations
+
+import "embed"
+
+//go:embed sqlite/*.sql postgres/*.sql
+var FS embed.FS
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS "links" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    url text UNIQUE NOT NULL,
+    title text,
+    description text,
+    saved_at timestamptz,
+    read_at timestamptz
+);
+
+CREATE INDEX idx_links_deleted_at ON links (deleted_at);
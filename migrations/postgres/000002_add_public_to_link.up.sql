@@ -0,0 +1 @@
+ALTER TABLE links ADD COLUMN public boolean NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE links ADD COLUMN tags text[];
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS "users" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    email text UNIQUE NOT NULL,
+    password text NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_users_email ON users (email);
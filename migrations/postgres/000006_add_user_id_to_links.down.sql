@@ -0,0 +1,6 @@
+DROP INDEX idx_links_user_id;
+
+ALTER TABLE links DROP CONSTRAINT links_user_id_url_key;
+ALTER TABLE links ADD CONSTRAINT links_url_key UNIQUE (url);
+
+ALTER TABLE links DROP COLUMN user_id;
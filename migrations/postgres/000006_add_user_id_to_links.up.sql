@@ -0,0 +1,17 @@
+-- links saved before there were users need someone to belong to: a placeholder, who can't log in until
+-- "bookmarks user passwd owner@localhost" gives them a password
+INSERT INTO users (created_at, updated_at, email, password, name)
+SELECT now(), now(), 'owner@localhost', '', 'owner'
+WHERE EXISTS (SELECT 1 FROM links) AND NOT EXISTS (SELECT 1 FROM users);
+
+ALTER TABLE links ADD COLUMN user_id bigint REFERENCES users (id);
+
+-- links saved before there were users belong to the first user
+UPDATE links SET user_id = (SELECT min(id) FROM users);
+
+ALTER TABLE links ALTER COLUMN user_id SET NOT NULL;
+
+ALTER TABLE links DROP CONSTRAINT links_url_key;
+ALTER TABLE links ADD CONSTRAINT links_user_id_url_key UNIQUE (user_id, url);
+
+CREATE INDEX idx_links_user_id ON links (user_id);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "sessions" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    user_id bigint NOT NULL REFERENCES users (id),
+    token_hash text UNIQUE NOT NULL,
+    last_seen_at timestamptz,
+    user_agent text,
+    ip_address text
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN totp_secret text;
+ALTER TABLE users ADD COLUMN totp_enabled boolean NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN totp_last_step bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS "recovery_codes" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    user_id bigint NOT NULL REFERENCES users (id),
+    code_hash text NOT NULL,
+    used_at timestamptz
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS "link_revisions" (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    link_id bigint NOT NULL REFERENCES links (id),
+    source text NOT NULL,
+    changes jsonb NOT NULL
+);
+
+CREATE INDEX idx_link_revisions_link_id ON link_revisions (link_id);
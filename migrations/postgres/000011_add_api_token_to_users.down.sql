@@ -0,0 +1,3 @@
+DROP INDEX idx_users_api_token_hash;
+
+ALTER TABLE users DROP COLUMN api_token_hash;
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN api_token_hash text;
+
+CREATE INDEX idx_users_api_token_hash ON users (api_token_hash);
@@ -0,0 +1,2 @@
+ALTER TABLE links DROP COLUMN word_count;
+ALTER TABLE links DROP COLUMN priority;
@@ -0,0 +1,2 @@
+ALTER TABLE links ADD COLUMN priority integer NOT NULL DEFAULT 0;
+ALTER TABLE links ADD COLUMN word_count integer NOT NULL DEFAULT 0;
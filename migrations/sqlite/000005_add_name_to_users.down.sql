@@ -0,0 +1,3 @@
+DROP INDEX idx_users_name;
+
+ALTER TABLE users DROP COLUMN name;
@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN name text;
+
+UPDATE users SET name = CASE
+    WHEN instr(email, '@') > 1 THEN substr(email, 1, instr(email, '@') - 1)
+    ELSE email
+END;
+
+-- users whose addresses have the same local part keep it apart by their ID, except for the first
+UPDATE users SET name = name || '-' || id WHERE id NOT IN (SELECT min(id) FROM users GROUP BY name);
+
+CREATE UNIQUE INDEX idx_users_name ON users (name);
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS "links_old" (
+    id integer PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    url text UNIQUE NOT NULL,
+    title text,
+    description text,
+    saved_at datetime,
+    read_at datetime,
+    public bool NOT NULL DEFAULT false,
+    tags text
+);
+
+INSERT INTO links_old (id, created_at, updated_at, deleted_at, url, title, description, saved_at, read_at, public, tags)
+SELECT id, created_at, updated_at, deleted_at, url, title, description, saved_at, read_at, public, tags
+FROM links;
+
+DROP TABLE links;
+
+ALTER TABLE links_old RENAME TO links;
+
+CREATE INDEX idx_links_deleted_at ON links (deleted_at);
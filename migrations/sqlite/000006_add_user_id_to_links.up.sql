@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS "links_new" (
+    id integer PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    deleted_at datetime,
+    user_id integer NOT NULL REFERENCES users (id),
+    url text NOT NULL,
+    title text,
+    description text,
+    saved_at datetime,
+    read_at datetime,
+    public bool NOT NULL DEFAULT false,
+    tags text,
+    UNIQUE (user_id, url)
+);
+
+-- links saved before there were users need someone to belong to: a placeholder, who can't log in until
+-- "bookmarks user passwd owner@localhost" gives them a password
+INSERT INTO users (created_at, updated_at, email, password, name)
+SELECT datetime('now'), datetime('now'), 'owner@localhost', '', 'owner'
+WHERE EXISTS (SELECT 1 FROM links) AND NOT EXISTS (SELECT 1 FROM users);
+
+-- links saved before there were users belong to the first user
+INSERT INTO links_new (
+    id, created_at, updated_at, deleted_at, user_id, url, title, description, saved_at, read_at, public, tags
+)
+SELECT
+    id, created_at, updated_at, deleted_at, (SELECT min(id) FROM users),
+    url, title, description, saved_at, read_at, public, tags
+FROM links;
+
+DROP TABLE links;
+
+ALTER TABLE links_new RENAME TO links;
+
+CREATE INDEX idx_links_deleted_at ON links (deleted_at);
+CREATE INDEX idx_links_user_id ON links (user_id);
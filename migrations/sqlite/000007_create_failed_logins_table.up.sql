@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS "failed_logins" (
+    id integer PRIMARY KEY,
+    created_at datetime,
+    email text,
+    ip_address text,
+    reason text
+);
+
+CREATE INDEX idx_failed_logins_created_at ON failed_logins (created_at);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "sessions" (
+    id integer PRIMARY KEY,
+    created_at datetime,
+    updated_at datetime,
+    user_id integer NOT NULL REFERENCES users (id),
+    token_hash text UNIQUE NOT NULL,
+    last_seen_at datetime,
+    user_agent text,
+    ip_address text
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN totp_secret text;
+ALTER TABLE users ADD COLUMN totp_enabled bool NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN totp_last_step integer NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS "recovery_codes" (
+    id integer PRIMARY KEY,
+    created_at datetime,
+    user_id integer NOT NULL REFERENCES users (id),
+    code_hash text NOT NULL,
+    used_at datetime
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
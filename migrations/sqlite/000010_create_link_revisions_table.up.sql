@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS "link_revisions" (
+    id integer PRIMARY KEY,
+    created_at datetime,
+    link_id integer NOT NULL REFERENCES links (id),
+    source text NOT NULL,
+    changes text NOT NULL
+);
+
+CREATE INDEX idx_link_revisions_link_id ON link_revisions (link_id);
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trustProxies replaces the remote address of requests from a trusted reverse proxy with the client's address
+// from X-Forwarded-For, so that rate limiting, sessions, logs and /metrics see the client rather than the proxy.
+func trustProxies(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	isTrusted := func(address string) bool {
+		ip := net.ParseIP(strings.TrimSpace(address))
+
+		for _, network := range trusted {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isTrusted(remoteIP(r)) {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			// each proxy appends the address it received the request from, so the client is the last address
+			// which isn't another trusted proxy; anything before that could have been sent by the client
+			forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+
+			for i := len(forwarded) - 1; i >= 0; i-- {
+				address := strings.TrimSpace(forwarded[i])
+				if net.ParseIP(address) == nil {
+					break
+				}
+
+				r.RemoteAddr = address
+
+				if !isTrusted(address) {
+					break
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
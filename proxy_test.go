@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/benjamineskola/bookmarks/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustProxies(t *testing.T) {
+	t.Parallel()
+
+	trusted := config.Server{TrustedProxies: []string{"10.0.0.0/8", "::1"}}.TrustedNetworks() //nolint:exhaustruct
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"untrusted peer", "192.0.2.1:1234", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"trusted peer", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted IPv6 peer", "[::1]:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", "10.0.0.1:1234", []string{"198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"spoofed by client", "10.0.0.1:1234", []string{"203.0.113.9, 198.51.100.1"}, "198.51.100.1"},
+		{"several headers", "10.0.0.1:1234", []string{"203.0.113.9", "198.51.100.1"}, "198.51.100.1"},
+		{"no header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"garbage", "10.0.0.1:1234", []string{"198.51.100.1, garbage"}, "10.0.0.1"},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			var got string
+
+			handler := trustProxies(trusted)(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = remoteIP(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = testCase.remoteAddr
+
+			for _, value := range testCase.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, testCase.want, got)
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxFailures = 5
+	defaultBaseDelay   = time.Second
+	defaultMaxDelay    = time.Minute
+	defaultLockout     = 15 * time.Minute
+
+	// attemptTimeout is how long an attempt holds its key if it is never finished.
+	attemptTimeout = 10 * time.Second
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// RateLimitEntry tracks recent failures for a single key, such as an IP address or an account.
+type RateLimitEntry struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+	// AttemptUntil is set while an attempt is in progress, so that others for the same key wait for it.
+	AttemptUntil time.Time
+	// Expires is when the entry no longer affects any attempt, and can be pruned.
+	Expires time.Time
+}
+
+// RateLimitStore holds rate limit entries; the in-memory store is enough for a single process.
+type RateLimitStore interface {
+	Get(key string) (RateLimitEntry, bool)
+	Set(key string, entry RateLimitEntry)
+	Delete(key string)
+	// Prune deletes the entries which expired before now.
+	Prune(now time.Time)
+}
+
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	entries map[string]RateLimitEntry
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{entries: make(map[string]RateLimitEntry)} //nolint:exhaustruct
+}
+
+func (s *MemoryRateLimitStore) Get(key string) (RateLimitEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+
+	return entry, ok
+}
+
+func (s *MemoryRateLimitStore) Set(key string, entry RateLimitEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = entry
+}
+
+func (s *MemoryRateLimitStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
+
+func (s *MemoryRateLimitStore) Prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, entry := range s.entries {
+		if entry.Expires.Before(now) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// RateLimiter applies exponential backoff between failed attempts, and locks a key out entirely
+// once it reaches MaxFailures. Only one attempt per key runs at a time, so that parallel requests
+// can't all be let through before any of them fails.
+type RateLimiter struct {
+	Store       RateLimitStore
+	Clock       Clock
+	MaxFailures int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Lockout     time.Duration
+
+	mu        sync.Mutex
+	nextPrune time.Time
+}
+
+func NewRateLimiter(store RateLimitStore, clock Clock) *RateLimiter {
+	return &RateLimiter{ //nolint:exhaustruct
+		Store:       store,
+		Clock:       clock,
+		MaxFailures: defaultMaxFailures,
+		BaseDelay:   defaultBaseDelay,
+		MaxDelay:    defaultMaxDelay,
+		Lockout:     defaultLockout,
+	}
+}
+
+func (rl *RateLimiter) backoff(failures int) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+
+	delay := time.Duration(float64(rl.BaseDelay) * math.Pow(2, float64(failures-1)))
+	if delay > rl.MaxDelay || delay <= 0 {
+		delay = rl.MaxDelay
+	}
+
+	return delay
+}
+
+// wait returns how long an attempt for the entry has to wait, or 0 if it can go ahead now.
+func (rl *RateLimiter) wait(entry RateLimitEntry, now time.Time) time.Duration {
+	if now.Before(entry.LockedUntil) {
+		return entry.LockedUntil.Sub(now)
+	}
+
+	if next := entry.LastFailure.Add(rl.backoff(entry.Failures)); now.Before(next) {
+		return next.Sub(now)
+	}
+
+	// the attempt in progress will usually finish well before it times out
+	if now.Before(entry.AttemptUntil) {
+		return min(entry.AttemptUntil.Sub(now), rl.BaseDelay)
+	}
+
+	return 0
+}
+
+// set stores the entry, or deletes it if it no longer affects any attempt.
+func (rl *RateLimiter) set(key string, entry RateLimitEntry, now time.Time) {
+	entry.Expires = entry.LockedUntil
+	for _, until := range []time.Time{entry.LastFailure.Add(rl.Lockout), entry.AttemptUntil} {
+		if until.After(entry.Expires) {
+			entry.Expires = until
+		}
+	}
+
+	if entry.Failures == 0 && !entry.Expires.After(now) {
+		rl.Store.Delete(key)
+	} else {
+		rl.Store.Set(key, entry)
+	}
+
+	if now.After(rl.nextPrune) {
+		rl.Store.Prune(now)
+		rl.nextPrune = now.Add(rl.Lockout)
+	}
+}
+
+// Allow reports whether an attempt for all of the keys may go ahead now, and if not, how long to wait.
+// An allowed attempt holds the keys until it is finished with Fail, Succeed or Release.
+func (rl *RateLimiter) Allow(keys ...string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.Clock.Now()
+	entries := make([]RateLimitEntry, len(keys))
+
+	var wait time.Duration
+
+	for i, key := range keys {
+		entries[i], _ = rl.Store.Get(key)
+		wait = max(wait, rl.wait(entries[i], now))
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+
+	for i, key := range keys {
+		entries[i].AttemptUntil = now.Add(attemptTimeout)
+		rl.set(key, entries[i], now)
+	}
+
+	return true, 0
+}
+
+// Fail records a failed attempt for each of the keys.
+func (rl *RateLimiter) Fail(keys ...string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.Clock.Now()
+
+	for _, key := range keys {
+		entry, _ := rl.Store.Get(key)
+
+		// failures from long enough ago are forgotten
+		if now.Sub(entry.LastFailure) > rl.Lockout {
+			entry.Failures = 0
+		}
+
+		entry.Failures++
+		entry.LastFailure = now
+		entry.AttemptUntil = time.Time{}
+
+		if entry.Failures >= rl.MaxFailures {
+			entry.Failures = 0
+			entry.LockedUntil = now.Add(rl.Lockout)
+		}
+
+		rl.set(key, entry, now)
+	}
+}
+
+// Succeed clears any recorded failures for each of the keys.
+func (rl *RateLimiter) Succeed(keys ...string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	for _, key := range keys {
+		rl.Store.Delete(key)
+	}
+}
+
+// Release finishes an attempt for each of the keys without clearing their failures, for keys such as an
+// IP address which are shared by more than one account.
+func (rl *RateLimiter) Release(keys ...string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.Clock.Now()
+
+	for _, key := range keys {
+		if entry, ok := rl.Store.Get(key); ok {
+			entry.AttemptUntil = time.Time{}
+			rl.set(key, entry, now)
+		}
+	}
+}
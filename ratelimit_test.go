@@ -0,0 +1,208 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func TestRateLimiterBacksOff(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), clock)
+
+	ok, _ := limiter.Allow("key")
+	assert.True(t, ok)
+
+	limiter.Fail("key")
+
+	ok, wait := limiter.Allow("key")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	clock.Advance(time.Second)
+
+	ok, _ = limiter.Allow("key")
+	assert.True(t, ok)
+
+	limiter.Fail("key")
+
+	ok, wait = limiter.Allow("key")
+	assert.False(t, ok)
+	assert.Equal(t, 2*time.Second, wait)
+}
+
+func TestRateLimiterLocksOut(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), clock)
+	limiter.MaxFailures = 3
+
+	for i := 0; i < 3; i++ {
+		limiter.Fail("key")
+		clock.Advance(time.Minute)
+	}
+
+	ok, wait := limiter.Allow("key")
+	assert.False(t, ok)
+	assert.Equal(t, 14*time.Minute, wait)
+
+	clock.Advance(14 * time.Minute)
+
+	ok, _ = limiter.Allow("key")
+	assert.True(t, ok)
+}
+
+func TestRateLimiterSucceedResets(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), clock)
+
+	limiter.Fail("key")
+	limiter.Succeed("key")
+
+	ok, _ := limiter.Allow("key")
+	assert.True(t, ok)
+
+	ok, _ = limiter.Allow("other")
+	assert.True(t, ok)
+}
+
+func TestRateLimiterHoldsAttempts(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), clock)
+
+	ok, _ := limiter.Allow("ip", "account")
+	assert.True(t, ok)
+
+	// another attempt on either key waits for the first to finish
+	ok, wait := limiter.Allow("ip")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	ok, _ = limiter.Allow("other", "account")
+	assert.False(t, ok)
+
+	ok, _ = limiter.Allow("other")
+	assert.True(t, ok)
+
+	limiter.Release("ip", "account")
+
+	ok, _ = limiter.Allow("ip", "account")
+	assert.True(t, ok)
+
+	// an attempt which never finishes eventually lets others go ahead
+	clock.Advance(attemptTimeout)
+
+	ok, _ = limiter.Allow("ip", "account")
+	assert.True(t, ok)
+}
+
+func TestRateLimiterParallelAttempts(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), clock)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if ok, _ := limiter.Allow("key"); ok {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+
+				limiter.Fail("key")
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 1, allowed)
+}
+
+func TestRateLimiterReleaseKeepsFailures(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), clock)
+
+	limiter.Fail("key")
+	clock.Advance(time.Second)
+
+	ok, _ := limiter.Allow("key")
+	assert.True(t, ok)
+
+	limiter.Release("key")
+	limiter.Fail("key")
+
+	ok, wait := limiter.Allow("key")
+	assert.False(t, ok)
+	assert.Equal(t, 2*time.Second, wait)
+}
+
+func TestRateLimiterPrunes(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+	store := NewMemoryRateLimitStore()
+	limiter := NewRateLimiter(store, clock)
+	limiter.MaxFailures = 2
+
+	limiter.Fail("forgotten")
+	limiter.Fail("locked")
+	limiter.Fail("locked")
+
+	ok, _ := limiter.Allow("released")
+	assert.True(t, ok)
+	limiter.Release("released")
+
+	_, ok = store.Get("released")
+	assert.False(t, ok)
+
+	clock.Advance(limiter.Lockout + time.Second)
+	limiter.Fail("new")
+
+	_, ok = store.Get("forgotten")
+	assert.False(t, ok)
+	_, ok = store.Get("locked")
+	assert.False(t, ok)
+	_, ok = store.Get("new")
+	assert.True(t, ok)
+}
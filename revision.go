@@ -0,0 +1,311 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RevisionSource string
+
+const (
+	RevisionSourceWeb    RevisionSource = "web"
+	RevisionSourceImport RevisionSource = "import"
+	RevisionSourceAPI    RevisionSource = "api"
+	RevisionSourceCLI    RevisionSource = "cli"
+)
+
+var errNoSuchRevision = errors.New("no such revision")
+
+type FieldChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+type RevisionChanges map[string]FieldChange
+
+func (rc *RevisionChanges) Scan(src any) error {
+	var data []byte
+
+	switch source := src.(type) {
+	case string:
+		data = []byte(source)
+	case []byte:
+		data = source
+	default:
+		return errIncompatibleType
+	}
+
+	if err := json.Unmarshal(data, rc); err != nil {
+		return fmt.Errorf("could not decode revision: %w", err)
+	}
+
+	return nil
+}
+
+func (rc RevisionChanges) Value() (driver.Value, error) {
+	data, err := json.Marshal(rc)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode revision: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// Fields returns the names of the changed fields in a stable order.
+func (rc RevisionChanges) Fields() []string {
+	fields := make([]string, 0, len(rc))
+	for field := range rc {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+
+	return fields
+}
+
+type LinkRevision struct {
+	ID        uint
+	CreatedAt time.Time
+	LinkID    uint
+	Source    RevisionSource
+	Changes   RevisionChanges
+}
+
+type revisionField struct {
+	Name string
+	Get  func(*Link) string
+	Set  func(*Link, string) error
+}
+
+func formatRevisionTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func parseRevisionTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time in revision: %w", err)
+	}
+
+	return parsed, nil
+}
+
+// revisionFields lists the fields of a link whose history is recorded.
+var revisionFields = []revisionField{ //nolint:gochecknoglobals
+	{
+		Name: "URL",
+		Get: func(l *Link) string {
+			if l.URL == nil {
+				return ""
+			}
+
+			return l.URL.String()
+		},
+		Set: func(l *Link, value string) error {
+			linkURL, err := parseURL(value)
+			if err != nil {
+				return err
+			}
+
+			l.URL = linkURL
+
+			return nil
+		},
+	},
+	{
+		Name: "Title",
+		Get:  func(l *Link) string { return l.Title },
+		Set: func(l *Link, value string) error {
+			l.Title = value
+
+			return nil
+		},
+	},
+	{
+		Name: "Description",
+		Get:  func(l *Link) string { return l.Description },
+		Set: func(l *Link, value string) error {
+			l.Description = value
+
+			return nil
+		},
+	},
+	{
+		Name: "SavedAt",
+		Get:  func(l *Link) string { return formatRevisionTime(l.SavedAt) },
+		Set: func(l *Link, value string) (err error) {
+			l.SavedAt, err = parseRevisionTime(value)
+
+			return err
+		},
+	},
+	{
+		Name: "ReadAt",
+		Get:  func(l *Link) string { return formatRevisionTime(l.ReadAt) },
+		Set: func(l *Link, value string) (err error) {
+			l.ReadAt, err = parseRevisionTime(value)
+
+			return err
+		},
+	},
+	{
+		Name: "Public",
+		Get:  func(l *Link) string { return strconv.FormatBool(l.Public) },
+		Set: func(l *Link, value string) (err error) {
+			l.Public, err = strconv.ParseBool(value)
+
+			return err //nolint:wrapcheck
+		},
+	},
+	{
+		Name: "Priority",
+		Get:  func(l *Link) string { return strconv.Itoa(l.Priority) },
+		Set: func(l *Link, value string) (err error) {
+			l.Priority, err = strconv.Atoi(value)
+
+			return err //nolint:wrapcheck
+		},
+	},
+	{
+		Name: "WordCount",
+		Get:  func(l *Link) string { return strconv.Itoa(l.WordCount) },
+		Set: func(l *Link, value string) (err error) {
+			l.WordCount, err = strconv.Atoi(value)
+
+			return err //nolint:wrapcheck
+		},
+	},
+	{
+		Name: "Tags",
+		Get: func(l *Link) string {
+			if l.Tags == nil {
+				return ""
+			}
+
+			tags := make([]string, 0, len(*l.Tags))
+			for tag := range *l.Tags {
+				if tag != "" {
+					tags = append(tags, tag)
+				}
+			}
+
+			sort.Strings(tags)
+
+			return strings.Join(tags, ",")
+		},
+		Set: func(l *Link, value string) error {
+			tl := NewTagListFromString(value)
+			delete(tl, "")
+			l.Tags = &tl
+
+			return nil
+		},
+	},
+}
+
+// diffLinks returns the recorded fields which differ between two versions of a link.
+func diffLinks(before *Link, after *Link) RevisionChanges {
+	changes := make(RevisionChanges)
+
+	for _, field := range revisionFields {
+		oldValue, newValue := field.Get(before), field.Get(after)
+		if oldValue != newValue {
+			changes[field.Name] = FieldChange{Old: oldValue, New: newValue}
+		}
+	}
+
+	return changes
+}
+
+func (s *GormStore) GetLinkRevisions(linkID uint) []LinkRevision {
+	var revisions []LinkRevision
+
+	s.db.Where("link_id = ?", linkID).Order("id desc").Find(&revisions)
+
+	return revisions
+}
+
+// RevertLink puts the link's recorded fields back to how they were just after the given revision,
+// by undoing every later revision in turn. The revert is itself saved as a new revision.
+func RevertLink(links LinkStore, link *Link, revisionID uint, source RevisionSource) error {
+	revisions := links.GetLinkRevisions(link.ID)
+
+	target := -1
+
+	for i, revision := range revisions {
+		if revision.ID == revisionID {
+			target = i
+
+			break
+		}
+	}
+
+	if target == -1 {
+		return errNoSuchRevision
+	}
+
+	var previousURL string
+	if link.URL != nil {
+		previousURL = link.URL.String()
+	}
+
+	// revisions are newest first, so everything before the target came after it
+	for _, revision := range revisions[:target] {
+		for _, field := range revisionFields {
+			if change, ok := revision.Changes[field.Name]; ok {
+				if err := field.Set(link, change.Old); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// as when saving, an earlier URL which is now in the trash should be restored rather than duplicated
+	if link.URL != nil && link.URL.String() != previousURL {
+		if links.GetTrashedLinkByURL(link.UserID, link.URL.String()).ID != 0 {
+			return errLinkTrashed
+		}
+	}
+
+	_, err := links.SaveLink(link, source)
+
+	return err //nolint:wrapcheck
+}
+
+// saveWithRevision saves the link and a record of what changed, in a single transaction.
+func saveWithRevision(tx *gorm.DB, link *Link, source RevisionSource) error {
+	before := &Link{} //nolint:exhaustruct
+	if link.ID != 0 {
+		tx.Unscoped().First(before, link.ID)
+	}
+
+	changes := diffLinks(before, link)
+
+	if err := tx.Save(link).Error; err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	revision := LinkRevision{LinkID: link.ID, Source: source, Changes: changes} //nolint:exhaustruct
+
+	return tx.Create(&revision).Error //nolint:wrapcheck
+}
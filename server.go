@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// systemd passes activated sockets starting at this file descriptor
+const systemdFirstFD = 3
+
+var errUnexpectedSockets = errors.New("expected exactly one socket from systemd")
+
+// listen returns the socket passed by systemd socket activation if there is one, so that the socket stays open
+// across restarts, and otherwise listens on addr.
+func listen(addr string) (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return net.Listen("tcp", addr) //nolint:wrapcheck
+	}
+
+	fds, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if fds != 1 {
+		return nil, fmt.Errorf("%w, got %d", errUnexpectedSockets, fds)
+	}
+
+	// child processes shouldn't think the socket is theirs
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+
+	file := os.NewFile(systemdFirstFD, "systemd socket")
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use socket from systemd: %w", err)
+	}
+
+	return listener, nil
+}
+
+// runServer serves until a signal arrives, then stops accepting connections and waits up to drainTimeout
+// for in-flight requests to finish.
+func runServer(server *http.Server, listener net.Listener, signals <-chan os.Signal, drainTimeout time.Duration) error {
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return fmt.Errorf("server failed: %w", err)
+	case sig := <-signals:
+		slog.Info("shutting down, waiting for requests to finish", "signal", sig.String(), "timeout", drainTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to finish requests: %w", err)
+	}
+
+	return nil
+}
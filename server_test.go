@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunServerFinishesRequests(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	server := &http.Server{ //nolint:exhaustruct
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			close(started)
+			<-release
+			_, _ = w.Write([]byte("saved"))
+		}),
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	addr := listener.Addr().String()
+	signals := make(chan os.Signal, 1)
+	stopped := make(chan error, 1)
+
+	go func() {
+		stopped <- runServer(server, listener, signals, 5*time.Second)
+	}()
+
+	responses := make(chan string, 1)
+
+	go func() {
+		resp, err := http.Get("http://" + addr) //nolint:noctx
+		if err != nil {
+			responses <- err.Error()
+
+			return
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		responses <- string(body)
+	}()
+
+	<-started
+
+	signals <- syscall.SIGTERM
+
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+
+		return err != nil
+	}, time.Second, 10*time.Millisecond, "should stop accepting connections")
+
+	close(release)
+
+	assert.Equal(t, "saved", <-responses)
+	assert.Nil(t, <-stopped)
+}
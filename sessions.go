@@ -0,0 +1,298 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+const (
+	sessionCookieName = "session"
+	sessionTokenBytes = 32
+
+	// after a correct password, users with two-factor authentication have this long to enter a code
+	pendingLoginCookieName = "pending_login"
+	pendingLoginMaxAge     = 5 * time.Minute
+
+	// last-seen times are only written back this often, rather than on every request
+	sessionTouchInterval = time.Minute
+)
+
+var (
+	errSessionExpired      = errors.New("session expired")
+	errPendingLoginExpired = errors.New("login expired, please start again")
+)
+
+type Session struct {
+	ID         uint
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uint
+	TokenHash  string
+	LastSeenAt time.Time
+	UserAgent  string
+	IPAddress  string
+}
+
+type pendingLogin struct {
+	UserID   uint
+	IssuedAt int64
+}
+
+type SessionManager struct {
+	users       UserStore
+	codec       *securecookie.SecureCookie
+	IdleTimeout time.Duration
+	MaxAge      time.Duration
+	Secure      bool
+}
+
+func NewSessionManager(
+	users UserStore, secretKey []byte, idleTimeout time.Duration, maxAge time.Duration, secure bool,
+) *SessionManager {
+	codec := securecookie.New(secretKey, nil)
+	codec.MaxAge(int(maxAge.Seconds()))
+
+	return &SessionManager{
+		users:       users,
+		codec:       codec,
+		IdleTimeout: idleTimeout,
+		MaxAge:      maxAge,
+		Secure:      secure,
+	}
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func (s Session) Expired(now time.Time, idleTimeout time.Duration, maxAge time.Duration) bool {
+	return now.Sub(s.LastSeenAt) > idleTimeout || now.Sub(s.CreatedAt) > maxAge
+}
+
+// Start creates a new session for user and sets its cookie.
+func (sm *SessionManager) Start(w http.ResponseWriter, r *http.Request, user *User) error {
+	tokenBytes := make([]byte, sessionTokenBytes)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return fmt.Errorf("could not generate session token: %w", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	session := Session{ //nolint:exhaustruct
+		UserID:     user.ID,
+		TokenHash:  hashSessionToken(token),
+		LastSeenAt: time.Now(),
+		UserAgent:  r.UserAgent(),
+		IPAddress:  remoteIP(r),
+	}
+
+	if err := forRequest(sm.users, r).CreateSession(&session); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	encoded, err := sm.codec.Encode(sessionCookieName, token)
+	if err != nil {
+		return fmt.Errorf("could not encode session cookie: %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{ //nolint:exhaustruct
+		Name:     sessionCookieName,
+		Value:    encoded,
+		Path:     "/",
+		MaxAge:   int(sm.MaxAge.Seconds()),
+		Secure:   sm.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// Load returns the session for the request's cookie, or an error if it is missing, invalid or expired.
+func (sm *SessionManager) Load(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, fmt.Errorf("no session cookie: %w", err)
+	}
+
+	var token string
+	if err := sm.codec.Decode(sessionCookieName, cookie.Value, &token); err != nil {
+		return nil, fmt.Errorf("invalid session cookie: %w", err)
+	}
+
+	session, err := forRequest(sm.users, r).GetSessionByTokenHash(hashSessionToken(token))
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	now := time.Now()
+
+	if session.Expired(now, sm.IdleTimeout, sm.MaxAge) {
+		_ = forRequest(sm.users, r).RevokeSession(session.UserID, session.ID)
+
+		return nil, errSessionExpired
+	}
+
+	if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		session.LastSeenAt = now
+		session.IPAddress = remoteIP(r)
+		_ = forRequest(sm.users, r).TouchSession(session)
+	}
+
+	return session, nil
+}
+
+// End deletes the request's session, if any, and clears its cookie.
+func (sm *SessionManager) End(w http.ResponseWriter, r *http.Request) {
+	if session, err := sm.Load(r); err == nil {
+		_ = forRequest(sm.users, r).RevokeSession(session.UserID, session.ID)
+	}
+
+	http.SetCookie(w, &http.Cookie{ //nolint:exhaustruct
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   sm.Secure,
+		HttpOnly: true,
+	})
+}
+
+// StartPendingLogin remembers that user has given the right password but still needs a second factor.
+func (sm *SessionManager) StartPendingLogin(w http.ResponseWriter, user *User) error {
+	encoded, err := sm.codec.Encode(pendingLoginCookieName,
+		pendingLogin{UserID: user.ID, IssuedAt: time.Now().Unix()})
+	if err != nil {
+		return fmt.Errorf("could not encode login cookie: %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{ //nolint:exhaustruct
+		Name:     pendingLoginCookieName,
+		Value:    encoded,
+		Path:     "/auth/",
+		MaxAge:   int(pendingLoginMaxAge.Seconds()),
+		Secure:   sm.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// PendingLoginUserID returns the ID of the user partway through logging in.
+func (sm *SessionManager) PendingLoginUserID(r *http.Request) (uint, error) {
+	cookie, err := r.Cookie(pendingLoginCookieName)
+	if err != nil {
+		return 0, errPendingLoginExpired
+	}
+
+	var pending pendingLogin
+	if err := sm.codec.Decode(pendingLoginCookieName, cookie.Value, &pending); err != nil {
+		return 0, errPendingLoginExpired
+	}
+
+	if time.Since(time.Unix(pending.IssuedAt, 0)) > pendingLoginMaxAge {
+		return 0, errPendingLoginExpired
+	}
+
+	return pending.UserID, nil
+}
+
+func (sm *SessionManager) EndPendingLogin(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{ //nolint:exhaustruct
+		Name:     pendingLoginCookieName,
+		Value:    "",
+		Path:     "/auth/",
+		MaxAge:   -1,
+		Secure:   sm.Secure,
+		HttpOnly: true,
+	})
+}
+
+// PurgeExpired deletes every session past its idle or absolute timeout.
+func (sm *SessionManager) PurgeExpired() error {
+	now := time.Now()
+
+	return sm.users.PurgeExpiredSessions(now.Add(-sm.IdleTimeout), now.Add(-sm.MaxAge)) //nolint:wrapcheck
+}
+
+func (s *GormStore) CreateSession(session *Session) error {
+	if err := s.db.Create(session).Error; err != nil {
+		return fmt.Errorf("could not save session: %w", err)
+	}
+
+	return nil
+}
+
+func (s *GormStore) GetSessionByTokenHash(tokenHash string) (*Session, error) {
+	var session Session
+	if err := s.db.Where("token_hash = ?", tokenHash).First(&session).Error; err != nil {
+		return nil, fmt.Errorf("unknown session: %w", err)
+	}
+
+	return &session, nil
+}
+
+func (s *GormStore) TouchSession(session *Session) error {
+	if err := s.db.Model(session).Select("last_seen_at", "ip_address").Updates(session).Error; err != nil {
+		return fmt.Errorf("could not update session: %w", err)
+	}
+
+	return nil
+}
+
+func (s *GormStore) GetSessionsForUser(userID uint) []Session {
+	var sessions []Session
+
+	s.db.Where("user_id = ?", userID).Order("last_seen_at desc").Find(&sessions)
+
+	return sessions
+}
+
+func (s *GormStore) RevokeSession(userID uint, sessionID uint) error {
+	result := s.db.Where("user_id = ?", userID).Delete(&Session{}, sessionID) //nolint:exhaustruct
+	if result.Error != nil {
+		return fmt.Errorf("could not revoke session: %w", result.Error)
+	}
+
+	return nil
+}
+
+func (s *GormStore) RevokeAllSessions(userID uint) error {
+	result := s.db.Where("user_id = ?", userID).Delete(&Session{}) //nolint:exhaustruct
+	if result.Error != nil {
+		return fmt.Errorf("could not revoke sessions: %w", result.Error)
+	}
+
+	return nil
+}
+
+func (s *GormStore) RevokeOtherSessions(userID uint, currentSessionID uint) error {
+	result := s.db.Where("user_id = ? and id != ?", userID, currentSessionID).
+		Delete(&Session{}) //nolint:exhaustruct
+	if result.Error != nil {
+		return fmt.Errorf("could not revoke sessions: %w", result.Error)
+	}
+
+	return nil
+}
+
+func (s *GormStore) PurgeExpiredSessions(idleBefore time.Time, createdBefore time.Time) error {
+	result := s.db.Where("last_seen_at < ? or created_at < ?", idleBefore, createdBefore).
+		Delete(&Session{}) //nolint:exhaustruct
+	if result.Error != nil {
+		return fmt.Errorf("could not purge sessions: %w", result.Error)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+// Submits the actions beside each link in the background and updates the link in place. Without this script,
+// the forms are submitted normally and redirect back to the page.
+"use strict";
+
+function isRead(link) {
+  // links which have never been read have the zero time
+  return !link.ReadAt.startsWith("0001-");
+}
+
+function updateLink(element, link) {
+  const state = {
+    read: isRead(link),
+    unread: !isRead(link),
+    public: link.Public,
+    private: !link.Public,
+  };
+
+  element.dataset.read = state.read;
+  element.dataset.public = state.public;
+  element.classList.toggle("link-read", state.read);
+  element.classList.toggle("link-unread", state.unread);
+
+  for (const item of element.querySelectorAll("[data-show]")) {
+    item.hidden = !state[item.dataset.show];
+  }
+}
+
+document.addEventListener("submit", async (event) => {
+  const form = event.target;
+  const element = form.closest('[data-controller="links"]');
+
+  if (!element || !form.classList.contains("link-action")) {
+    return;
+  }
+
+  event.preventDefault();
+
+  for (const button of form.querySelectorAll("button")) {
+    button.disabled = true;
+  }
+
+  try {
+    const response = await fetch(`${form.action}.json`, {
+      method: "POST",
+      body: new FormData(form),
+      credentials: "same-origin",
+      headers: { Accept: "application/json" },
+    });
+
+    if (!response.ok) {
+      throw new Error(response.statusText);
+    }
+
+    const result = await response.json();
+
+    if (form.action.endsWith("/delete")) {
+      element.remove();
+    } else {
+      updateLink(element, result);
+    }
+  } catch {
+    // let the server show what went wrong
+    form.submit();
+  } finally {
+    for (const button of form.querySelectorAll("button")) {
+      button.disabled = false;
+    }
+  }
+});
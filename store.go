@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LinkFilter selects which links GetLinks returns; the zero value selects all of them.
+type LinkFilter struct {
+	OnlyPublic bool
+	// OnlyRead also sorts the links by when they were read, rather than when they were saved.
+	OnlyRead bool
+	// OnlyUnread sorts the links by priority first, making a reading queue.
+	OnlyUnread bool
+	// Sort is the field the links are sorted by, one of linkSorts. By default they are sorted by when they were
+	// saved, except that read links are sorted by when they were read, and unread ones by priority first.
+	Sort string
+	// Ascending sorts oldest first, or from A to Z, rather than the other way round.
+	Ascending bool
+	// Tag matches links with exactly this tag.
+	Tag string
+	// Search matches links whose title, URL or description contain it, ignoring case.
+	Search string
+	// URL matches the link saved at this URL, once normalised.
+	URL string
+}
+
+// LinkStore holds links along with their revision history and the trash.
+type LinkStore interface {
+	// GetLinks returns a page of links belonging to the given user, or of all users if userID is 0,
+	// along with the number of links matching the filter.
+	GetLinks(userID uint, filter LinkFilter, page int, count int) (*[]Link, int64)
+	// GetLinksFrom returns up to count links after the cursor, sorted by when they were saved, or before it if the
+	// cursor pages backwards; a nil cursor starts at the beginning. It also returns the number of links matching
+	// the filter, and whether there are more beyond those returned.
+	GetLinksFrom(userID uint, filter LinkFilter, cursor *LinkCursor, count int) (*[]Link, int64, bool)
+	// GetLastModified returns when any of the user's links, including those in the trash, was last changed or
+	// deleted, and how many there are. Any view of the links changes only when one of these does, whatever it
+	// filters on: a link leaving a view, by becoming private or being deleted, changes it too.
+	GetLastModified(userID uint) (time.Time, int64)
+	// GetWordCount returns the total word count of the links matching the filter, and how many of them have one.
+	GetWordCount(userID uint, filter LinkFilter) (int64, int64)
+	GetLinkByID(userID uint, id uint) (*Link, error)
+	GetLinkByURL(userID uint, url string) (*Link, error)
+	// SaveLink saves the link, recording a revision of any changed fields.
+	SaveLink(link *Link, source RevisionSource) (uint, error)
+	// DeleteLink moves the link to the trash.
+	DeleteLink(userID uint, id uint) error
+	// UpdateLinks makes the change to all of the given links in one transaction, failing with errLinkNotFound
+	// if any of them doesn't exist.
+	UpdateLinks(userID uint, ids []uint, change BulkChange, source RevisionSource) (int64, error)
+	// GetLinkRevisions returns the link's history, newest first.
+	GetLinkRevisions(linkID uint) []LinkRevision
+
+	GetTrashedLinks(userID uint, page int, count int) (*[]Link, int64)
+	GetTrashedLinkByURL(userID uint, url string) *Link
+	RestoreLinks(userID uint, ids []uint) (int64, error)
+	// PurgeLinks permanently deletes links from the trash; links not in the trash are left alone.
+	PurgeLinks(userID uint, ids []uint) (int64, error)
+	EmptyTrash(userID uint) (int64, error)
+	// PurgeExpiredLinks permanently deletes every user's links that have been in the trash longer than retention.
+	PurgeExpiredLinks(retention time.Duration) (int64, error)
+}
+
+// UserStore holds users and everything needed to authenticate them.
+type UserStore interface {
+	// The GetUserBy functions return a user with a zero ID if there is no match.
+	GetUserByID(id uint) *User
+	GetUserByEmail(email string) *User
+	GetUserByName(name string) *User
+	GetUserByAPITokenHash(tokenHash string) *User
+	GetUsers() []User
+	CreateUser(user *User) error
+	// UpdateUser saves only the named columns of the user.
+	UpdateUser(user *User, columns ...string) error
+	// DeleteUser removes the user along with their links and their history, sessions and recovery codes.
+	DeleteUser(user *User) error
+	LogFailedLogin(email string, ipAddress string, reason string) error
+
+	// ReplaceRecoveryCodes deletes the user's recovery codes and stores the given ones instead.
+	ReplaceRecoveryCodes(userID uint, codes []RecoveryCode) error
+	// UseRecoveryCode marks an unused code as used, reporting whether there was one.
+	UseRecoveryCode(userID uint, codeHash string) (bool, error)
+
+	CreateSession(session *Session) error
+	GetSessionByTokenHash(tokenHash string) (*Session, error)
+	// TouchSession saves the session's last-seen time and IP address.
+	TouchSession(session *Session) error
+	GetSessionsForUser(userID uint) []Session
+	RevokeSession(userID uint, sessionID uint) error
+	RevokeAllSessions(userID uint) error
+	RevokeOtherSessions(userID uint, currentSessionID uint) error
+	// PurgeExpiredSessions deletes sessions last seen before idleBefore or created before createdBefore.
+	PurgeExpiredSessions(idleBefore time.Time, createdBefore time.Time) error
+}
+
+// GormStore implements LinkStore and UserStore on top of a SQL database.
+type GormStore struct {
+	db *gorm.DB
+}
+
+var (
+	_ LinkStore = (*GormStore)(nil)
+	_ UserStore = (*GormStore)(nil)
+)
+
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+// WithContext returns a copy of the store whose queries use the context, which carries the request's logger.
+func (s *GormStore) WithContext(ctx context.Context) *GormStore {
+	return &GormStore{db: s.db.WithContext(ctx)}
+}
+
+// forRequest returns the store with the request's context, so that slow or failed queries are logged with the
+// request; stores without a database are returned as they are.
+func forRequest[S any](store S, r *http.Request) S {
+	if gormStore, ok := any(store).(*GormStore); ok {
+		return any(gormStore.WithContext(r.Context())).(S) //nolint:forcetypeassert
+	}
+
+	return store
+}
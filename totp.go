@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // RFC 6238 uses SHA-1 by default, and authenticator apps expect it
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+const (
+	totpIssuer      = "Bookmarks"
+	totpDigits      = 6
+	totpPeriod      = 30
+	totpSecretBytes = 20
+	// codes from one step either side of the current one are accepted, to allow for clock drift
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 5
+	qrCodeSize        = 256
+)
+
+var (
+	errInvalidTOTPCode    = errors.New("invalid authentication code")
+	errTOTPNotEnabled     = errors.New("two-factor authentication is not enabled")
+	errTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding) //nolint:gochecknoglobals
+
+type RecoveryCode struct {
+	ID        uint
+	CreatedAt time.Time
+	UserID    uint
+	CodeHash  string
+	UsedAt    *time.Time
+}
+
+func NewTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("could not generate secret: %w", err)
+	}
+
+	return base32NoPadding.EncodeToString(secret), nil
+}
+
+func totpStep(now time.Time) int64 {
+	return now.Unix() / totpPeriod
+}
+
+// totpCode computes the RFC 6238 code for the given secret and time step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid secret: %w", err)
+	}
+
+	counter := make([]byte, 8) //nolint:gomnd
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f                                    //nolint:gomnd
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff //nolint:gomnd
+
+	modulus := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulus *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%modulus), nil
+}
+
+// matchTOTPStep returns the time step that code is valid for, if any, within the allowed skew.
+func matchTOTPStep(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	current := totpStep(now)
+
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func (u *User) TOTPURI() string {
+	label := url.PathEscape(totpIssuer + ":" + u.Email)
+	params := url.Values{}
+	params.Set("secret", u.TOTPSecret)
+	params.Set("issuer", totpIssuer)
+
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
+
+// TOTPQRCode returns the user's shared secret as a QR code, suitable for use as an image src.
+func (u *User) TOTPQRCode() (template.URL, error) {
+	png, err := qrcode.Encode(u.TOTPURI(), qrcode.Medium, qrCodeSize)
+	if err != nil {
+		return "", fmt.Errorf("could not generate QR code: %w", err)
+	}
+
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)), nil //nolint:gosec
+}
+
+// BeginTOTPEnrolment stores a new, not yet enabled, secret for the user.
+func (u *User) BeginTOTPEnrolment(users UserStore) error {
+	if u.TOTPEnabled {
+		return errTOTPAlreadyEnabled
+	}
+
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		return err
+	}
+
+	u.TOTPSecret = secret
+	u.TOTPLastStep = 0
+
+	if err := users.UpdateUser(u, "totp_secret", "totp_last_step"); err != nil {
+		return fmt.Errorf("could not save secret: %w", err)
+	}
+
+	return nil
+}
+
+// EnableTOTP confirms enrolment with a code from the user's authenticator, and returns a fresh set
+// of recovery codes to show them once.
+func (u *User) EnableTOTP(users UserStore, code string) ([]string, error) {
+	if u.TOTPEnabled {
+		return nil, errTOTPAlreadyEnabled
+	}
+
+	if u.TOTPSecret == "" {
+		return nil, errTOTPNotEnabled
+	}
+
+	step, ok := matchTOTPStep(u.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, errInvalidTOTPCode
+	}
+
+	u.TOTPEnabled = true
+	u.TOTPLastStep = step
+
+	if err := users.UpdateUser(u, "totp_enabled", "totp_last_step"); err != nil {
+		return nil, fmt.Errorf("could not enable two-factor authentication: %w", err)
+	}
+
+	return u.RegenerateRecoveryCodes(users)
+}
+
+// ResetTOTP turns off two-factor authentication and removes the secret and any recovery codes.
+func (u *User) ResetTOTP(users UserStore) error {
+	u.TOTPSecret = ""
+	u.TOTPEnabled = false
+	u.TOTPLastStep = 0
+
+	if err := users.UpdateUser(u, "totp_secret", "totp_enabled", "totp_last_step"); err != nil {
+		return fmt.Errorf("could not reset two-factor authentication: %w", err)
+	}
+
+	if err := users.ReplaceRecoveryCodes(u.ID, nil); err != nil {
+		return fmt.Errorf("could not delete recovery codes: %w", err)
+	}
+
+	return nil
+}
+
+// ValidateTOTP checks a code from the user's authenticator, refusing any code that has already been used.
+func (u *User) ValidateTOTP(users UserStore, code string) error {
+	if !u.TOTPEnabled {
+		return errTOTPNotEnabled
+	}
+
+	step, ok := matchTOTPStep(u.TOTPSecret, code, time.Now())
+	if !ok || step <= u.TOTPLastStep {
+		return errInvalidTOTPCode
+	}
+
+	u.TOTPLastStep = step
+
+	if err := users.UpdateUser(u, "totp_last_step"); err != nil {
+		return fmt.Errorf("could not save authentication code: %w", err)
+	}
+
+	return nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, returning the new ones in plain text.
+func (u *User) RegenerateRecoveryCodes(users UserStore) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]RecoveryCode, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, fmt.Errorf("could not generate recovery code: %w", err)
+		}
+
+		code := strings.ToLower(base32NoPadding.EncodeToString(raw))
+		code = code[:4] + "-" + code[4:]
+
+		codes = append(codes, code)
+		records = append(records, RecoveryCode{UserID: u.ID, CodeHash: hashRecoveryCode(code)}) //nolint:exhaustruct
+	}
+
+	if err := users.ReplaceRecoveryCodes(u.ID, records); err != nil {
+		return nil, fmt.Errorf("could not save recovery codes: %w", err)
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode checks a recovery code and marks it as used.
+func (u *User) UseRecoveryCode(users UserStore, code string) error {
+	if !u.TOTPEnabled {
+		return errTOTPNotEnabled
+	}
+
+	used, err := users.UseRecoveryCode(u.ID, hashRecoveryCode(code))
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if !used {
+		return errInvalidTOTPCode
+	}
+
+	return nil
+}
+
+func (s *GormStore) ReplaceRecoveryCodes(userID uint, codes []RecoveryCode) error {
+	return s.db.Transaction(func(tx *gorm.DB) error { //nolint:wrapcheck
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil { //nolint:exhaustruct
+			return err //nolint:wrapcheck
+		}
+
+		if len(codes) == 0 {
+			return nil
+		}
+
+		return tx.Create(&codes).Error //nolint:wrapcheck
+	})
+}
+
+func (s *GormStore) UseRecoveryCode(userID uint, codeHash string) (bool, error) {
+	query := s.db.Model(&RecoveryCode{}) //nolint:exhaustruct
+	result := query.Where("user_id = ? and code_hash = ? and used_at is null", userID, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, fmt.Errorf("could not use recovery code: %w", result.Error)
+	}
+
+	return result.RowsAffected > 0, nil
+}
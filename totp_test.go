@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTPCode(t *testing.T) {
+	t.Parallel()
+
+	// test vectors from RFC 6238, truncated to six digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	testCases := []struct {
+		Time     int64
+		Expected string
+	}{
+		{Time: 59, Expected: "287082"},
+		{Time: 1111111109, Expected: "081804"},
+		{Time: 1111111111, Expected: "050471"},
+		{Time: 1234567890, Expected: "005924"},
+		{Time: 2000000000, Expected: "279037"},
+	}
+
+	for _, tc := range testCases {
+		actual, err := totpCode(secret, totpStep(time.Unix(tc.Time, 0)))
+		assert.Nil(t, err)
+		assert.Equal(t, tc.Expected, actual)
+	}
+}
+
+func TestTOTPEnrolment(t *testing.T) {
+	t.Parallel()
+
+	user, err := NewUser("totp", "totp@example.com", "password")
+	assert.Nil(t, err)
+	assert.Nil(t, testStore.CreateUser(user))
+
+	assert.Nil(t, user.BeginTOTPEnrolment(testStore))
+	assert.NotEmpty(t, user.TOTPSecret)
+
+	code, err := totpCode(user.TOTPSecret, totpStep(time.Now()))
+	assert.Nil(t, err)
+
+	recoveryCodes, err := user.EnableTOTP(testStore, code)
+	assert.Nil(t, err)
+	assert.Len(t, recoveryCodes, recoveryCodeCount)
+
+	reloaded := testStore.GetUserByID(user.ID)
+	assert.True(t, reloaded.TOTPEnabled)
+	assert.Equal(t, user.TOTPSecret, reloaded.TOTPSecret)
+
+	// the code used to enrol can't be used again
+	assert.ErrorIs(t, reloaded.ValidateTOTP(testStore, code), errInvalidTOTPCode)
+
+	assert.Nil(t, reloaded.UseRecoveryCode(testStore, recoveryCodes[0]))
+	assert.ErrorIs(t, reloaded.UseRecoveryCode(testStore, recoveryCodes[0]), errInvalidTOTPCode)
+
+	assert.Nil(t, reloaded.ResetTOTP(testStore))
+	assert.False(t, testStore.GetUserByID(user.ID).TOTPEnabled)
+}
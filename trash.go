@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/benjamineskola/bookmarks/config"
+	"gorm.io/gorm"
+)
+
+const trashPurgeInterval = time.Hour
+
+// errLinkTrashed refuses to save a new link when the same URL is in the trash, as it should be restored instead.
+var errLinkTrashed = errors.New("link is in the trash")
+
+func trashed(db *gorm.DB) *gorm.DB {
+	return db.Unscoped().Where("deleted_at is not null")
+}
+
+func (s *GormStore) GetTrashedLinks(userID uint, page int, count int) (*[]Link, int64) {
+	var links []Link
+
+	if page < 1 {
+		page = 1
+	}
+
+	if count < 1 {
+		count = config.DefaultPageSize
+	}
+
+	query := s.db.Scopes(trashed).Where("user_id = ?", userID)
+
+	var totalCount int64
+
+	query.Model(&Link{}).Count(&totalCount) //nolint:exhaustruct
+	query.Order("deleted_at desc").Limit(count).Offset((page - 1) * count).Find(&links)
+
+	return &links, totalCount
+}
+
+func (s *GormStore) GetTrashedLinkByURL(userID uint, url string) *Link {
+	var link Link
+
+	s.db.Scopes(trashed).Where("user_id = ?", userID).Scopes(matchingURL(url)).First(&link)
+
+	return &link
+}
+
+func (s *GormStore) RestoreLinks(userID uint, ids []uint) (int64, error) {
+	query := s.db.Model(&Link{}).Scopes(trashed) //nolint:exhaustruct
+	result := query.Where("user_id = ? and id in ?", userID, ids).Update("deleted_at", nil)
+	if result.Error != nil {
+		return 0, fmt.Errorf("could not restore links: %w", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// purgeTrashed permanently deletes the trashed links matching conditions, along with their history.
+func (s *GormStore) purgeTrashed(conditions func(*gorm.DB) *gorm.DB) (int64, error) {
+	var count int64
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		ids := tx.Model(&Link{}).Scopes(trashed, conditions).Select("id") //nolint:exhaustruct
+
+		if err := tx.Where("link_id in (?)", ids).Delete(&LinkRevision{}).Error; err != nil { //nolint:exhaustruct
+			return err //nolint:wrapcheck
+		}
+
+		result := tx.Scopes(trashed, conditions).Delete(&Link{}) //nolint:exhaustruct
+		count = result.RowsAffected
+
+		return result.Error //nolint:wrapcheck
+	})
+
+	return count, err //nolint:wrapcheck
+}
+
+func (s *GormStore) PurgeLinks(userID uint, ids []uint) (int64, error) {
+	count, err := s.purgeTrashed(func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ? and id in ?", userID, ids)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not purge links: %w", err)
+	}
+
+	return count, nil
+}
+
+func (s *GormStore) EmptyTrash(userID uint) (int64, error) {
+	count, err := s.purgeTrashed(func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ?", userID)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not empty trash: %w", err)
+	}
+
+	return count, nil
+}
+
+func (s *GormStore) PurgeExpiredLinks(retention time.Duration) (int64, error) {
+	count, err := s.purgeTrashed(func(db *gorm.DB) *gorm.DB {
+		return db.Where("deleted_at < ?", time.Now().Add(-retention))
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not purge expired links: %w", err)
+	}
+
+	return count, nil
+}
+
+func purgeTrashPeriodically(ctx context.Context, links LinkStore, retention time.Duration) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		count, err := links.PurgeExpiredLinks(retention)
+		recordJob("trash-purge", err)
+
+		if err != nil {
+			slog.Warn("could not purge the trash", "error", err)
+		} else if count > 0 {
+			slog.Info("purged links from the trash", "count", count)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
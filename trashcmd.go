@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/benjamineskola/bookmarks/config"
+)
+
+// parseLinkIDs reads the link IDs among a command's arguments, which are wrong if any isn't a number.
+func parseLinkIDs(args []string) ([]uint, error) {
+	ids := make([]uint, 0, len(args))
+
+	for _, arg := range args {
+		linkID, err := strconv.ParseUint(arg, 10, 0)
+		if err != nil {
+			return nil, errUsage
+		}
+
+		ids = append(ids, uint(linkID))
+	}
+
+	return ids, nil
+}
+
+// trashCommands are the subcommands of "bookmarks trash".
+func trashCommands() []command {
+	return []command{
+		{
+			name: "list", args: "EMAIL", summary: "List a user's links in the trash.",
+			setup: withoutFlags(exactArgs(1, storeCommand(func(store *GormStore, args []string) error {
+				return trashList(store, args[0])
+			}))),
+		},
+		{
+			name: "restore", args: "EMAIL ID...", summary: "Restore a user's links from the trash.",
+			setup: withoutFlags(rangeArgs(2, -1, storeCommand(func(store *GormStore, args []string) error { //nolint:gomnd
+				ids, err := parseLinkIDs(args[1:])
+				if err != nil {
+					return err
+				}
+
+				return trashRestore(store, args[0], ids)
+			}))),
+		},
+		{
+			name: "purge", args: "[EMAIL [ID...]]",
+			summary: "Delete links for good: those past the retention period, or all or some of a user's.",
+			setup:   withoutFlags(storeCommand(trashPurge)),
+		},
+	}
+}
+
+func trashList(store *GormStore, email string) error {
+	user, err := getUserByEmail(store, email)
+	if err != nil {
+		return err
+	}
+
+	pageSize := config.Config.Pagination.PageSize
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+
+	fmt.Fprintln(writer, "ID\tDELETED\tURL\tTITLE")
+
+	for page := 1; ; page++ {
+		links, total := store.GetTrashedLinks(user.ID, page, pageSize)
+
+		for _, link := range *links {
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n",
+				link.ID, link.DeletedAt.Time.Format(time.DateOnly), link.URL, link.Title)
+		}
+
+		if int64(page*pageSize) >= total {
+			break
+		}
+	}
+
+	writer.Flush()
+
+	return nil
+}
+
+func trashRestore(store *GormStore, email string, ids []uint) error {
+	user, err := getUserByEmail(store, email)
+	if err != nil {
+		return err
+	}
+
+	count, err := store.RestoreLinks(user.ID, ids)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	log.Printf("restored %d links", count)
+
+	return nil
+}
+
+func trashPurge(store *GormStore, args []string) error {
+	if len(args) == 0 {
+		count, err := store.PurgeExpiredLinks(config.Config.Features.TrashRetention)
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		log.Printf("purged %d links", count)
+
+		return nil
+	}
+
+	user, err := getUserByEmail(store, args[0])
+	if err != nil {
+		return err
+	}
+
+	ids, err := parseLinkIDs(args[1:])
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	if len(ids) == 0 {
+		count, err = store.EmptyTrash(user.ID)
+	} else {
+		count, err = store.PurgeLinks(user.ID, ids)
+	}
+
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	log.Printf("purged %d links", count)
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"net/url"
 	"slices"
 	"strings"
@@ -9,16 +10,21 @@ import (
 	"gorm.io/datatypes"
 )
 
-func parseURL(urlString string) *datatypes.URL {
-	parsedURL, _ := url.Parse(urlString)
+// parseURL parses and normalises a URL for saving in a link.
+func parseURL(urlString string) (*datatypes.URL, error) {
+	parsedURL, err := url.Parse(urlString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
 	normalisedURL := normaliseURL(*parsedURL)
 	gormURL := datatypes.URL(normalisedURL)
 
-	return &gormURL
+	return &gormURL, nil
 }
 
-func normaliseAddWWW(inputURL url.URL) url.URL {
-	normalisationAddWWW := config.Config.URLNormalisations.AddWWW
+func normaliseAddWWW(inputURL url.URL, rules *config.URLNormalisations) url.URL {
+	normalisationAddWWW := rules.AddWWW
 
 	if slices.Contains(normalisationAddWWW, inputURL.Host) {
 		inputURL.Host = "www." + inputURL.Host
@@ -27,8 +33,8 @@ func normaliseAddWWW(inputURL url.URL) url.URL {
 	return inputURL
 }
 
-func normaliseRemoveWWW(inputURL url.URL) url.URL {
-	normalisationRemoveWWW := config.Config.URLNormalisations.RemoveWWW
+func normaliseRemoveWWW(inputURL url.URL, rules *config.URLNormalisations) url.URL {
+	normalisationRemoveWWW := rules.RemoveWWW
 
 	if slices.Contains(normalisationRemoveWWW, inputURL.Host) {
 		inputURL.Host = strings.TrimPrefix(inputURL.Host, "www.")
@@ -37,8 +43,8 @@ func normaliseRemoveWWW(inputURL url.URL) url.URL {
 	return inputURL
 }
 
-func normaliseReplaceDomain(inputURL url.URL) url.URL {
-	normalisationReplaceDomain := config.Config.URLNormalisations.ReplaceDomain
+func normaliseReplaceDomain(inputURL url.URL, rules *config.URLNormalisations) url.URL {
+	normalisationReplaceDomain := rules.ReplaceDomain
 
 	if newDomain := normalisationReplaceDomain[inputURL.Host]; newDomain != "" {
 		inputURL.Host = newDomain
@@ -47,8 +53,8 @@ func normaliseReplaceDomain(inputURL url.URL) url.URL {
 	return inputURL
 }
 
-func normaliseForceHTTPS(inputURL url.URL) url.URL {
-	normalisationForceHTTPS := config.Config.URLNormalisations.ForceHTTPS
+func normaliseForceHTTPS(inputURL url.URL, rules *config.URLNormalisations) url.URL {
+	normalisationForceHTTPS := rules.ForceHTTPS
 
 	if slices.Contains(normalisationForceHTTPS, inputURL.Host) && inputURL.Scheme != "https" {
 		inputURL.Scheme = "https"
@@ -58,27 +64,30 @@ func normaliseForceHTTPS(inputURL url.URL) url.URL {
 }
 
 func normaliseURL(inputURL url.URL) url.URL {
-	if config.Config.URLNormalisations.AddWWW == nil {
-		config.LoadConfig()
-	}
+	// the rules can be reloaded at any time, so use the same ones throughout
+	rules := config.Normalisations()
 
-	inputURL = normaliseAddWWW(inputURL)
-	inputURL = normaliseRemoveWWW(inputURL)
-	inputURL = normaliseReplaceDomain(inputURL)
+	inputURL = normaliseAddWWW(inputURL, rules)
+	inputURL = normaliseRemoveWWW(inputURL, rules)
+	inputURL = normaliseReplaceDomain(inputURL, rules)
 
 	// special case
 	if inputURL.Host == "medium.com" || strings.HasSuffix(inputURL.Host, ".medium.com") {
 		inputURL.Host = "scribe.rip"
 	}
 
-	inputURL = normaliseForceHTTPS(inputURL)
+	inputURL = normaliseForceHTTPS(inputURL, rules)
 
 	return inputURL
 }
 
-func normaliseURLString(inputURL string) string {
-	parsedURL, _ := url.Parse(inputURL)
+func normaliseURLString(inputURL string) (string, error) {
+	parsedURL, err := url.Parse(inputURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+
 	normalisedURL := normaliseURL(*parsedURL)
 
-	return normalisedURL.String()
+	return normalisedURL.String(), nil
 }
@@ -4,16 +4,12 @@ import (
 	"net/url"
 	"testing"
 
-	"github.com/benjamineskola/bookmarks/config"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestURLAddWWW(t *testing.T) {
 	t.Parallel()
 
-	config.Config = config.MakeConfig()
-	config.Config.URLNormalisations.AddWWW = []string{"theguardian.com"}
-
 	testCases := []struct {
 		Input    string
 		Expected string
@@ -35,3 +31,15 @@ func TestURLAddWWW(t *testing.T) {
 		})
 	}
 }
+
+func TestParseURLMalformed(t *testing.T) {
+	t.Parallel()
+
+	_, err := parseURL("http://[::1")
+	assert.ErrorContains(t, err, "invalid URL")
+
+	_, err = normaliseURLString("http://[::1")
+	assert.ErrorContains(t, err, "invalid URL")
+
+	assert.Equal(t, []string{"http://[::1"}, matchingURLs("http://[::1"))
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+)
+
+var errPasswordMismatch = errors.New("passwords do not match")
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// setEcho turns terminal echo on or off, so that passwords aren't shown as they're typed.
+func setEcho(enabled bool) error {
+	arg := "-echo"
+	if enabled {
+		arg = "echo"
+	}
+
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("could not change terminal settings: %w", err)
+	}
+
+	return nil
+}
+
+func promptPassword(reader *bufio.Reader, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	if err := setEcho(false); err != nil {
+		return "", err
+	}
+
+	line, err := reader.ReadString('\n')
+
+	fmt.Fprintln(os.Stderr)
+
+	if echoErr := setEcho(true); echoErr != nil {
+		return "", echoErr
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("could not read password: %w", err)
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readPassword prompts for a password twice on a terminal, or reads a single line from stdin otherwise,
+// so that passwords never need to appear on the command line.
+func readPassword() (string, error) {
+	reader := bufio.NewReader(os.Stdin)
+
+	if !isTerminal(os.Stdin) {
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("could not read password: %w", err)
+		}
+
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	password, err := promptPassword(reader, "Password: ")
+	if err != nil {
+		return "", err
+	}
+
+	confirmation, err := promptPassword(reader, "Confirm password: ")
+	if err != nil {
+		return "", err
+	}
+
+	if password != confirmation {
+		return "", errPasswordMismatch
+	}
+
+	return password, nil
+}
+
+func getUserByEmail(users UserStore, email string) (*User, error) {
+	user := users.GetUserByEmail(email)
+	if user.ID == 0 {
+		return nil, fmt.Errorf("%w: %q", errNoSuchUser, email)
+	}
+
+	return user, nil
+}
+
+// storeCommand wraps a command which works directly on the local database.
+func storeCommand(run func(store *GormStore, args []string) error) func(args []string) error {
+	return func(args []string) error {
+		return run(NewGormStore(openDatabase()), args)
+	}
+}
+
+// userCommands are the subcommands of "bookmarks user".
+func userCommands() []command {
+	return []command{
+		{
+			name: "add", args: "EMAIL [NAME]", summary: "Add a user, with a password read from standard input.",
+			setup: withoutFlags(rangeArgs(1, 2, storeCommand(func(store *GormStore, args []string) error { //nolint:gomnd
+				var name string
+				if len(args) > 1 {
+					name = args[1]
+				}
+
+				return userAdd(store, args[0], name)
+			}))),
+		},
+		{
+			name: "passwd", args: "EMAIL", summary: "Change a user's password and log them out everywhere.",
+			setup: withoutFlags(exactArgs(1, storeCommand(func(store *GormStore, args []string) error {
+				return userPasswd(store, args[0])
+			}))),
+		},
+		{
+			name: "delete", args: "EMAIL", summary: "Delete a user and their links.",
+			setup: withoutFlags(exactArgs(1, storeCommand(func(store *GormStore, args []string) error {
+				return userDelete(store, args[0])
+			}))),
+		},
+		{
+			name: "list", args: "", summary: "List the users.",
+			setup: withoutFlags(exactArgs(0, storeCommand(func(store *GormStore, _ []string) error {
+				userList(store)
+
+				return nil
+			}))),
+		},
+		{
+			name: "reset2fa", args: "EMAIL", summary: "Turn off a user's two-factor authentication.",
+			setup: withoutFlags(exactArgs(1, storeCommand(func(store *GormStore, args []string) error {
+				return userResetTwoFactor(store, args[0])
+			}))),
+		},
+		{
+			name: "token", args: "EMAIL", summary: "Print a new API token for a user, replacing any they had.",
+			setup: withoutFlags(exactArgs(1, storeCommand(func(store *GormStore, args []string) error {
+				return userToken(store, args[0])
+			}))),
+		},
+	}
+}
+
+func userAdd(store *GormStore, email string, name string) error {
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		name = freeUserName(store, defaultUserName(normaliseEmail(email)))
+	}
+
+	user, err := NewUser(name, email, password)
+	if err != nil {
+		return fmt.Errorf("could not create user: %w", err)
+	}
+
+	return store.CreateUser(user) //nolint:wrapcheck
+}
+
+func userPasswd(store *GormStore, email string) error {
+	user, err := getUserByEmail(store, email)
+	if err != nil {
+		return err
+	}
+
+	password, err := readPassword()
+	if err != nil {
+		return err
+	}
+
+	if err := user.SetPassword(password); err != nil {
+		return fmt.Errorf("could not change password: %w", err)
+	}
+
+	if err := store.UpdateUser(user, "password"); err != nil {
+		return fmt.Errorf("could not change password: %w", err)
+	}
+
+	if err := store.RevokeAllSessions(user.ID); err != nil {
+		return fmt.Errorf("could not revoke sessions: %w", err)
+	}
+
+	return nil
+}
+
+func userDelete(store *GormStore, email string) error {
+	user, err := getUserByEmail(store, email)
+	if err != nil {
+		return err
+	}
+
+	return store.DeleteUser(user) //nolint:wrapcheck
+}
+
+func userList(store *GormStore) {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:gomnd
+
+	fmt.Fprintln(writer, "ID\tNAME\tEMAIL\t2FA")
+
+	for _, user := range store.GetUsers() {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%t\n", user.ID, user.Name, user.Email, user.TOTPEnabled)
+	}
+
+	writer.Flush()
+}
+
+func userResetTwoFactor(store *GormStore, email string) error {
+	user, err := getUserByEmail(store, email)
+	if err != nil {
+		return err
+	}
+
+	if err := user.ResetTOTP(store); err != nil {
+		return fmt.Errorf("could not reset two-factor authentication: %w", err)
+	}
+
+	if err := store.RevokeAllSessions(user.ID); err != nil {
+		return fmt.Errorf("could not revoke sessions: %w", err)
+	}
+
+	return nil
+}
+
+// userToken prints a new API token for the command-line client, replacing any the user had before.
+func userToken(store *GormStore, email string) error {
+	user, err := getUserByEmail(store, email)
+	if err != nil {
+		return err
+	}
+
+	token, err := user.ResetAPIToken()
+	if err != nil {
+		return err
+	}
+
+	if err := store.UpdateUser(user, "api_token_hash"); err != nil {
+		return fmt.Errorf("could not save API token: %w", err)
+	}
+
+	fmt.Println(token)
+
+	return nil
+}